@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/logging"
+	"github.com/csutorasa/icon-metrics/metrics"
+)
+
+// Debug state of a single device.
+type deviceDebug struct {
+	SysId string `json:"sysId"`
+	Url   string `json:"url"`
+	Mode  string `json:"mode"`
+	// Set if the client has a session.
+	LoggedIn  bool       `json:"loggedIn"`
+	LoginTime *time.Time `json:"loginTime"`
+	// Seconds since the login, 0 if not logged in.
+	SessionAge          float64    `json:"sessionAgeSeconds"`
+	Connected           bool       `json:"connected"`
+	LastSuccess         *time.Time `json:"lastSuccess"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	// Time of the last datapoll response.
+	LastRead *time.Time `json:"lastRead"`
+	// Last datapoll response body with the secrets redacted.
+	LastBody json.RawMessage `json:"lastBody"`
+}
+
+// HTTP server with the profiling and debug endpoints.
+type adminServer struct {
+	server  *http.Server
+	network string
+	manager *deviceManager
+}
+
+// Creates a new admin server for the devices of the manager.
+func newAdminServer(configuration *config.AdminConfiguration, manager *deviceManager) *adminServer {
+	admin := &adminServer{manager: manager}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/goroutines", admin.goroutines)
+	mux.HandleFunc("GET /debug/devices", admin.devices)
+	network, address := configuration.ListenAddress()
+	admin.network = network
+	admin.server = &http.Server{
+		Addr:           address,
+		Handler:        mux,
+		ReadTimeout:    10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+	return admin
+}
+
+// Starts to listen and serve.
+func (admin *adminServer) start() error {
+	ln, err := metrics.Listen(admin.network, admin.server.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		admin.server.Serve(ln)
+	}()
+	return nil
+}
+
+// Stops serving and listening.
+func (admin *adminServer) close() error {
+	return admin.server.Close()
+}
+
+// Writes the stack traces of every goroutine.
+func (admin *adminServer) goroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// Writes the session state and the last read values of the running devices.
+func (admin *adminServer) devices(w http.ResponseWriter, r *http.Request) {
+	devices := make([]*deviceDebug, 0)
+	for _, runner := range admin.manager.running() {
+		clientState := runner.client.State()
+		sessionState := runner.session.State()
+		device := &deviceDebug{
+			SysId:               runner.configuration.SysId,
+			Url:                 logging.RedactUrl(runner.configuration.Url),
+			Mode:                runner.configuration.Mode,
+			LoggedIn:            clientState.LoggedIn,
+			Connected:           sessionState.Connected,
+			ConsecutiveFailures: sessionState.ConsecutiveFailures,
+			LastBody:            clientState.LastBody,
+		}
+		if clientState.LoggedIn {
+			device.LoginTime = &clientState.LoginTime
+			device.SessionAge = time.Since(clientState.LoginTime).Seconds()
+		}
+		if !sessionState.LastSuccess.IsZero() {
+			device.LastSuccess = &sessionState.LastSuccess
+		}
+		if !clientState.LastBodyTime.IsZero() {
+			device.LastRead = &clientState.LastBodyTime
+		}
+		devices = append(devices, device)
+	}
+	writeJson(w, http.StatusOK, devices)
+}
@@ -6,13 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"path"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/csutorasa/icon-metrics/logging"
 	"github.com/csutorasa/icon-metrics/metrics"
 	"github.com/csutorasa/icon-metrics/model"
 )
@@ -24,6 +28,21 @@ type IconClient interface {
 	IconClientWriter
 	// Returns the system ID.
 	SysId() string
+	// Returns the logger with the device attributes.
+	Logger() *slog.Logger
+	// Returns the session state and the last read values, safe to call from any goroutine.
+	State() ClientState
+}
+
+// Session state of the client.
+type ClientState struct {
+	LoggedIn bool
+	// Time of the login, zero if not logged in.
+	LoginTime time.Time
+	// Last datapoll response body with the secrets redacted, nil if there was none.
+	LastBody json.RawMessage
+	// Time of the last datapoll response, zero if there was none.
+	LastBodyTime time.Time
 }
 
 type iconHttpClient struct {
@@ -33,8 +52,19 @@ type iconHttpClient struct {
 	password  string
 	sessionId string
 	session   metrics.MetricsSession
+	logger    *slog.Logger
+	// Guards the state against concurrent State calls.
+	lock sync.Mutex
+	// Time of the login, zero if not logged in.
+	loginTime time.Time
+	// Last datapoll response body.
+	lastBody     []byte
+	lastBodyTime time.Time
 }
 
+// Datapoll response fields, which are redacted from the state.
+var secretFields = []string{"EMAIL", "PASS", "PASSWORD", "PWD", "TOKEN"}
+
 // session cookie name
 const phpSessionId = "PHPSESSID"
 
@@ -63,6 +93,7 @@ func NewIconClient(urlStr string, sysId string, password string, session metrics
 		password:  password,
 		sessionId: "",
 		session:   session,
+		logger:    logging.Device(sysId, urlStr),
 	}, nil
 }
 
@@ -71,6 +102,11 @@ func (client *iconHttpClient) SysId() string {
 	return client.sysId
 }
 
+// Returns the logger with the device attributes.
+func (client *iconHttpClient) Logger() *slog.Logger {
+	return client.logger
+}
+
 // Join path to the base URL.
 func (client *iconHttpClient) getPath(p string) (*url.URL, error) {
 	u, err := url.Parse(client.url.String())
@@ -84,20 +120,137 @@ func (client *iconHttpClient) getPath(p string) (*url.URL, error) {
 // Removes metrics and session data.
 func (client *iconHttpClient) removeSession() {
 	client.sessionId = ""
+	client.lock.Lock()
+	client.loginTime = time.Time{}
+	client.lock.Unlock()
 }
 
-// Unmarshal JSON content from http response body.
-func unmarshalBody(res *http.Response, v any) error {
+// Returns the session state and the last read values, safe to call from any goroutine.
+func (client *iconHttpClient) State() ClientState {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	state := ClientState{
+		LoggedIn:     !client.loginTime.IsZero(),
+		LoginTime:    client.loginTime,
+		LastBodyTime: client.lastBodyTime,
+	}
+	if client.lastBody != nil {
+		state.LastBody = redactBody(client.lastBody)
+	}
+	return state
+}
+
+// Returns the JSON body with the secret fields replaced.
+func redactBody(body []byte) json.RawMessage {
+	var value any
+	err := json.Unmarshal(body, &value)
+	if err != nil {
+		redacted, _ := json.Marshal("<invalid json redacted>")
+		return redacted
+	}
+	redacted, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// Replaces the values of the secret fields recursively.
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if slices.Contains(secretFields, strings.ToUpper(key)) {
+				v[key] = "<redacted>"
+			} else {
+				v[key] = redactValue(child)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = redactValue(child)
+		}
+	}
+	return value
+}
+
+// Sends the form to the device and reads the response body.
+func (client *iconHttpClient) post(exchange *metrics.HttpExchange, u *url.URL, formData url.Values) (*http.Response, []byte, error) {
+	encoded := formData.Encode()
+	exchange.RequestSize = len(encoded)
+	client.logger.Debug("Sending request", logging.Operation(exchange.Name), slog.String("path", u.Path), slog.Any("fields", redactForm(formData)), slog.Int("size", exchange.RequestSize))
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(encoded))
+	if err != nil {
+		exchange.Error = errorRequest
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if client.sessionId != "" {
+		req.AddCookie(&http.Cookie{Name: phpSessionId, Value: client.sessionId})
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := client.client.Do(req)
+	if err != nil {
+		exchange.Error = classifyError(err)
+		return nil, nil, fmt.Errorf("failed to execute http call: %w", err)
+	}
+	defer res.Body.Close()
+	exchange.StatusCode = res.StatusCode
 	body, err := io.ReadAll(io.LimitReader(res.Body, maxReadBytes))
+	exchange.ResponseSize = len(body)
 	if err != nil {
-		return err
+		exchange.Error = classifyError(err)
+		return res, nil, fmt.Errorf("failed to read response: %w", err)
 	}
 	if len(body) == maxReadBytes {
-		return fmt.Errorf("too long response body")
+		exchange.Error = errorBodyTooLarge
+		return res, nil, errBodyTooLarge
 	}
-	err = json.Unmarshal(body, v)
+	return res, body, nil
+}
+
+// Logs and reports the finished exchange.
+func (client *iconHttpClient) finish(exchange *metrics.HttpExchange) {
+	client.logger.Debug("Request finished", logging.Operation(exchange.Name), slog.Int("status", exchange.StatusCode), slog.Int("size", exchange.ResponseSize), slog.Duration("duration", exchange.Duration()), slog.String("error", exchange.Error))
+	client.session.HttpClientRequest(exchange)
+}
+
+// Returns the form fields with the password replaced.
+func redactForm(formData url.Values) map[string]string {
+	fields := make(map[string]string, len(formData))
+	for key := range formData {
+		if key == "password" {
+			fields[key] = "<redacted>"
+		} else {
+			fields[key] = formData.Get(key)
+		}
+	}
+	return fields
+}
+
+// Unmarshal JSON content from http response body.
+func unmarshalBody(exchange *metrics.HttpExchange, body []byte, v any) error {
+	err := json.Unmarshal(body, v)
 	if err != nil {
-		return err
+		exchange.Error = errorJsonParse
+		return fmt.Errorf("failed to parse json: %w", err)
+	}
+	return nil
+}
+
+// Checks the response status code.
+func checkStatusCode(exchange *metrics.HttpExchange, res *http.Response) error {
+	if res.StatusCode != http.StatusOK {
+		exchange.Error = errorStatusCode
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Checks the action result.
+func checkActionResponse(exchange *metrics.HttpExchange, data *model.ActionResponse) error {
+	if !data.IsSuccess() {
+		exchange.Error = errorDeviceRejected
+		return data.CreateError()
 	}
 	return nil
 }
@@ -126,7 +279,8 @@ type HttpSessionClient interface {
 
 // Logs in and creates a session.
 func (client *iconHttpClient) Login() error {
-	timer := metrics.NewTimer()
+	exchange := metrics.NewHttpExchange("login")
+	defer client.finish(exchange)
 	formData := url.Values{
 		"sysid":    []string{client.sysId},
 		"password": []string{client.password},
@@ -134,66 +288,56 @@ func (client *iconHttpClient) Login() error {
 		"tab":      []string{"login"},
 		"form":     []string{"login"},
 	}
-	req, err := http.NewRequest(http.MethodPost, client.url.String(), strings.NewReader(formData.Encode()))
+	client.removeSession()
+	res, body, err := client.post(exchange, client.url, formData)
 	if err != nil {
-		client.session.HttpClientRequest("login", 0, timer.End())
-		return fmt.Errorf("failed to create request: %s", err)
+		return err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	res, err := client.client.Do(req)
+	err = checkStatusCode(exchange, res)
 	if err != nil {
-		client.session.HttpClientRequest("login", 0, timer.End())
-		return fmt.Errorf("failed to execute http call: %w", err)
-	}
-	defer res.Body.Close()
-	client.session.HttpClientRequest("login", res.StatusCode, timer.End())
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to login, status code %d", res.StatusCode)
+		return fmt.Errorf("failed to login: %w", err)
 	}
 	if !client.updateCookie(res.Cookies()) {
+		exchange.Error = errorDeviceRejected
 		return errors.New("no session was found")
 	}
 	data := model.ActionResponse{}
-	err = unmarshalBody(res, &data)
+	err = unmarshalBody(exchange, body, &data)
 	if err != nil {
 		client.removeSession()
-		return fmt.Errorf("failed to parse json: %w", err)
+		return err
 	}
-	if !data.IsSuccess() {
+	err = checkActionResponse(exchange, &data)
+	if err != nil {
 		client.removeSession()
-		return data.CreateError()
+		return err
 	}
+	client.lock.Lock()
+	client.loginTime = time.Now()
+	client.lock.Unlock()
 	return nil
 }
 
 // Closes a session.
 func (client *iconHttpClient) Logout() error {
-	timer := metrics.NewTimer()
+	exchange := metrics.NewHttpExchange("logout")
+	defer client.finish(exchange)
 	fomrData := url.Values{
 		"logout": []string{"true"},
 	}
 	url, err := client.getPath("index.php")
 	if err != nil {
-		client.session.HttpClientRequest("logout", 0, timer.End())
+		exchange.Error = errorRequest
 		return err
 	}
-	req, err := http.NewRequest("POST", url.String(), strings.NewReader(fomrData.Encode()))
+	res, _, err := client.post(exchange, url, fomrData)
+	client.removeSession()
 	if err != nil {
-		client.session.HttpClientRequest("logout", 0, timer.End())
-		return fmt.Errorf("failed to create request: %s", err)
+		return err
 	}
-	req.AddCookie(&http.Cookie{Name: phpSessionId, Value: client.sessionId})
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	res, err := client.client.Do(req)
+	err = checkStatusCode(exchange, res)
 	if err != nil {
-		client.session.HttpClientRequest("logout", 0, timer.End())
-		return fmt.Errorf("failed to execute http call: %w", err)
-	}
-	defer res.Body.Close()
-	client.session.HttpClientRequest("logout", res.StatusCode, timer.End())
-	client.removeSession()
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to logout, status code %d", res.StatusCode)
+		return fmt.Errorf("failed to logout: %w", err)
 	}
 	return nil
 }
@@ -217,40 +361,36 @@ type IconClientReader interface {
 
 // Reads data from the device.
 func (client *iconHttpClient) ReadValues() (*model.DataPollResponse, error) {
-	timer := metrics.NewTimer()
+	exchange := metrics.NewHttpExchange("read_values")
+	defer client.finish(exchange)
 	fomrData := url.Values{
 		"tab": []string{"datapoll"},
 	}
 	url, err := client.getPath("index.php")
 	if err != nil {
-		client.session.HttpClientRequest("read_values", 0, timer.End())
+		exchange.Error = errorRequest
 		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, url.String(), strings.NewReader(fomrData.Encode()))
-	if err != nil {
-		client.session.HttpClientRequest("read_values", 0, timer.End())
-		return nil, fmt.Errorf("failed to create request: %s", err)
-	}
-	req.AddCookie(&http.Cookie{Name: phpSessionId, Value: client.sessionId})
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	res, err := client.client.Do(req)
+	res, body, err := client.post(exchange, url, fomrData)
 	if err != nil {
-		client.session.HttpClientRequest("read_values", 0, timer.End())
 		client.removeSession()
-		return nil, fmt.Errorf("failed to execute http call: %w", err)
+		return nil, err
 	}
-	defer res.Body.Close()
-	client.session.HttpClientRequest("read_values", res.StatusCode, timer.End())
-	if res.StatusCode != http.StatusOK {
+	err = checkStatusCode(exchange, res)
+	if err != nil {
 		client.removeSession()
-		return nil, fmt.Errorf("failed to read data, status code %d", res.StatusCode)
+		return nil, fmt.Errorf("failed to read data: %w", err)
 	}
 	client.updateCookie(res.Cookies())
+	client.lock.Lock()
+	client.lastBody = body
+	client.lastBodyTime = time.Now()
+	client.lock.Unlock()
 	data := &model.DataPollResponse{}
-	err = unmarshalBody(res, &data)
+	err = unmarshalBody(exchange, body, data)
 	if err != nil {
 		client.removeSession()
-		return data, fmt.Errorf("failed to parse json: %w", err)
+		return data, err
 	}
 	return data, nil
 }
@@ -268,84 +408,41 @@ type IconClientWriter interface {
 
 // Experimental!
 func (client *iconHttpClient) SetThermostatSettings(tab int, thermosSettings model.ThermostatSettings) error {
-	timer := metrics.NewTimer()
-	formData := getValues(thermosSettings.ToValues(tab))
-	url, err := client.getPath("index.php")
-	if err != nil {
-		client.session.HttpClientRequest("set_thermostat_settings", 0, timer.End())
-		return err
-	}
-	req, err := http.NewRequest(http.MethodPost, url.String(), strings.NewReader(formData.Encode()))
-	if err != nil {
-		client.session.HttpClientRequest("set_thermostat_settings", 0, timer.End())
-		return err
-	}
-	req.AddCookie(&http.Cookie{Name: phpSessionId, Value: client.sessionId})
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	res, err := client.client.Do(req)
-	if err != nil {
-		client.session.HttpClientRequest("set_thermostat_settings", 0, timer.End())
-		client.removeSession()
-		return err
-	}
-	defer res.Body.Close()
-	client.session.HttpClientRequest("set_thermostat_settings", res.StatusCode, timer.End())
-	if res.StatusCode != 200 {
-		client.removeSession()
-		return fmt.Errorf("failed to read data, status code %d", res.StatusCode)
-	}
-	client.updateCookie(res.Cookies())
-	data := &model.ActionResponse{}
-	err = unmarshalBody(res, data)
-	if err != nil {
-		client.removeSession()
-		return err
-	}
-	if !data.IsSuccess() {
-		return data.CreateError()
-	}
-	return nil
+	return client.sendSettings("set_thermostat_settings", getValues(thermosSettings.ToValues(tab)))
 }
 
 // Experimental!
 func (client *iconHttpClient) SetGeneralSettings(tab int, generalSettings *model.GeneralSettings) error {
-	timer := metrics.NewTimer()
-	formData := getValues(generalSettings.ToValues(tab))
+	return client.sendSettings("set_general_settings", getValues(generalSettings.ToValues(tab)))
+}
+
+// Experimental!
+func (client *iconHttpClient) sendSettings(name string, formData url.Values) error {
+	exchange := metrics.NewHttpExchange(name)
+	defer client.finish(exchange)
 	url, err := client.getPath("index.php")
 	if err != nil {
-		client.session.HttpClientRequest("set_general_settings", 0, timer.End())
-		return err
-	}
-	req, err := http.NewRequest("POST", url.String(), strings.NewReader(formData.Encode()))
-	if err != nil {
-		client.session.HttpClientRequest("set_general_settings", 0, timer.End())
+		exchange.Error = errorRequest
 		return err
 	}
-	req.AddCookie(&http.Cookie{Name: phpSessionId, Value: client.sessionId})
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	res, err := client.client.Do(req)
+	res, body, err := client.post(exchange, url, formData)
 	if err != nil {
-		client.session.HttpClientRequest("set_general_settings", 0, timer.End())
 		client.removeSession()
 		return err
 	}
-	defer res.Body.Close()
-	client.session.HttpClientRequest("set_general_settings", res.StatusCode, timer.End())
-	if res.StatusCode != http.StatusOK {
+	err = checkStatusCode(exchange, res)
+	if err != nil {
 		client.removeSession()
-		return fmt.Errorf("failed to read data, status code %d", res.StatusCode)
+		return fmt.Errorf("failed to send settings: %w", err)
 	}
 	client.updateCookie(res.Cookies())
 	data := &model.ActionResponse{}
-	err = unmarshalBody(res, data)
+	err = unmarshalBody(exchange, body, data)
 	if err != nil {
 		client.removeSession()
 		return err
 	}
-	if !data.IsSuccess() {
-		return data.CreateError()
-	}
-	return nil
+	return checkActionResponse(exchange, data)
 }
 
 // Experimental!
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "secret fields are redacted",
+			body:     `{"SYSID":"123456789012","PASS":"secret","EMAIL":"user@example.com"}`,
+			expected: `{"EMAIL":"<redacted>","PASS":"<redacted>","SYSID":"123456789012"}`,
+		},
+		{
+			name:     "field names are matched case insensitively",
+			body:     `{"password":"secret","Token":"abc","pwd":1}`,
+			expected: `{"Token":"<redacted>","password":"<redacted>","pwd":"<redacted>"}`,
+		},
+		{
+			name:     "nested objects and lists are redacted",
+			body:     `{"DP":{"1":{"NA":"Living room","PASS":"secret"}},"USERS":[{"EMAIL":"user@example.com"}]}`,
+			expected: `{"DP":{"1":{"NA":"Living room","PASS":"<redacted>"}},"USERS":[{"EMAIL":"<redacted>"}]}`,
+		},
+		{
+			name:     "secret objects are redacted entirely",
+			body:     `{"TOKEN":{"value":"abc"}}`,
+			expected: `{"TOKEN":"<redacted>"}`,
+		},
+		{
+			name:     "bodies without secrets are kept",
+			body:     `[1,"PASS",null]`,
+			expected: `[1,"PASS",null]`,
+		},
+		{
+			name:     "invalid json is redacted",
+			body:     `PASS=secret`,
+			expected: `"<invalid json redacted>"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actual, expected any
+			err := json.Unmarshal(redactBody([]byte(test.body)), &actual)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = json.Unmarshal([]byte(test.expected), &expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/csutorasa/icon-metrics/model"
+)
+
+// Maximum size of the response bodies read during the discovery.
+const discoverBodyLimit = 1 << 20
+
+// Login page signature, the login form has a sysid field.
+var loginPageSignature = regexp.MustCompile(`(?i)name\s*=\s*["']?sysid\b`)
+
+// iCON device found on the network.
+type DiscoveredDevice struct {
+	Url string
+	// System ID of the device, empty if the datapoll is not reachable without login.
+	SysId string
+	// Firmware version of the device, empty if the datapoll is not reachable without login.
+	Version string
+}
+
+// Returns the device at the url, nil if the url does not answer like an iCON device.
+// The datapoll is tried first, then the login page is checked for the signature.
+func Identify(ctx context.Context, httpClient *http.Client, urlStr string) (*DiscoveredDevice, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url %s: %w", urlStr, err)
+	}
+	values, err := identifyDatapoll(ctx, httpClient, u.JoinPath("index.php"))
+	if err != nil {
+		return nil, err
+	}
+	if values != nil {
+		return &DiscoveredDevice{Url: urlStr, SysId: values.SysId, Version: values.Version}, nil
+	}
+	found, err := identifyLoginPage(ctx, httpClient, u)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &DiscoveredDevice{Url: urlStr}, nil
+}
+
+// Returns the datapoll values, nil if the response is not a datapoll response.
+func identifyDatapoll(ctx context.Context, httpClient *http.Client, u *url.URL) (*model.DataPollResponse, error) {
+	formData := url.Values{
+		"tab": []string{"datapoll"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(formData.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := identifyRequest(httpClient, req)
+	if err != nil || body == nil {
+		return nil, err
+	}
+	values := &model.DataPollResponse{}
+	if json.Unmarshal(body, values) != nil || values.SysId == "" {
+		return nil, nil
+	}
+	return values, nil
+}
+
+// Returns if the page has the login form of the device.
+func identifyLoginPage(ctx context.Context, httpClient *http.Client, u *url.URL) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	body, err := identifyRequest(httpClient, req)
+	if err != nil || body == nil {
+		return false, err
+	}
+	return loginPageSignature.Match(body), nil
+}
+
+// Returns the response body, nil if the status code is not OK.
+func identifyRequest(httpClient *http.Client, req *http.Request) ([]byte, error) {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(res.Body, discoverBodyLimit))
+}
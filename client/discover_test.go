@@ -0,0 +1,73 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Answers the datapoll with the values if they are set, serves the page on the other requests.
+func identifyServer(t *testing.T, datapoll string, page string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/index.php" && r.PostFormValue("tab") == "datapoll" && datapoll != "" {
+			w.Write([]byte(datapoll))
+			return
+		}
+		if r.Method == http.MethodGet && r.URL.Path == "/" && page != "" {
+			w.Write([]byte(page))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIdentifyDatapoll(t *testing.T) {
+	server := identifyServer(t, `{"SYSID":"123456789012","VER":"1.2.3","DP":{}}`, "")
+
+	device, err := Identify(t.Context(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device == nil || device.Url != server.URL || device.SysId != "123456789012" || device.Version != "1.2.3" {
+		t.Errorf("device is not identified by the datapoll, got %+v", device)
+	}
+}
+
+func TestIdentifyLoginPage(t *testing.T) {
+	server := identifyServer(t, "", `<form method="post"><input type="text" NAME = 'sysid'><input type="password" name="password"></form>`)
+
+	device, err := Identify(t.Context(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device == nil || device.SysId != "" || device.Version != "" {
+		t.Errorf("device is not identified by the login page, got %+v", device)
+	}
+}
+
+func TestIdentifyOtherServer(t *testing.T) {
+	for name, server := range map[string]*httptest.Server{
+		"not found":            identifyServer(t, "", ""),
+		"json without sysid":   identifyServer(t, `{"status":"ok"}`, ""),
+		"router login page":    identifyServer(t, "", `<form><input name="username"><input name="sysidentifier"></form>`),
+		"datapoll is not json": identifyServer(t, "<html>index</html>", "<html>index</html>"),
+	} {
+		device, err := Identify(t.Context(), server.Client(), server.URL)
+		if err != nil || device != nil {
+			t.Errorf("%s: server is identified as a device: %+v %v", name, device, err)
+		}
+	}
+}
+
+func TestIdentifyUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	device, err := Identify(t.Context(), http.DefaultClient, server.URL)
+	if err == nil || device != nil {
+		t.Errorf("unreachable server is not an error, got %+v", device)
+	}
+}
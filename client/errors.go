@@ -0,0 +1,58 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+)
+
+// HTTP client error classifications.
+const (
+	errorRequest        = "request"
+	errorDns            = "dns"
+	errorDialTimeout    = "dial_timeout"
+	errorDial           = "dial"
+	errorTls            = "tls"
+	errorReadTimeout    = "read_timeout"
+	errorBodyTooLarge   = "body_too_large"
+	errorJsonParse      = "json_parse"
+	errorStatusCode     = "status_code"
+	errorDeviceRejected = "device_rejected"
+	errorOther          = "other"
+)
+
+// Response body is larger than the limit.
+var errBodyTooLarge = errors.New("too long response body")
+
+// Returns the classification of a failed HTTP call.
+func classifyError(err error) string {
+	if errors.Is(err, errBodyTooLarge) {
+		return errorBodyTooLarge
+	}
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) {
+		return errorDns
+	}
+	var recordHeaderError tls.RecordHeaderError
+	var certificateVerificationError *tls.CertificateVerificationError
+	var unknownAuthorityError x509.UnknownAuthorityError
+	var hostnameError x509.HostnameError
+	var certificateInvalidError x509.CertificateInvalidError
+	if errors.As(err, &recordHeaderError) || errors.As(err, &certificateVerificationError) ||
+		errors.As(err, &unknownAuthorityError) || errors.As(err, &hostnameError) || errors.As(err, &certificateInvalidError) {
+		return errorTls
+	}
+	var opError *net.OpError
+	if errors.As(err, &opError) && opError.Op == "dial" {
+		if opError.Timeout() {
+			return errorDialTimeout
+		}
+		return errorDial
+	}
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return errorReadTimeout
+	}
+	return errorOther
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Returns the error of a GET request to the url.
+func requestError(t *testing.T, httpClient *http.Client, url string) error {
+	t.Helper()
+	res, err := httpClient.Get(url)
+	if err == nil {
+		res.Body.Close()
+		t.Fatalf("request to %s succeeded", url)
+	}
+	return err
+}
+
+func TestClassifyErrorDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	err = requestError(t, http.DefaultClient, "http://"+address)
+	if actual := classifyError(err); actual != errorDial {
+		t.Errorf("expected %s, got %s for %v", errorDial, actual, err)
+	}
+}
+
+func TestClassifyErrorTls(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	err := requestError(t, http.DefaultClient, server.URL)
+	if actual := classifyError(err); actual != errorTls {
+		t.Errorf("expected %s, got %s for %v", errorTls, actual, err)
+	}
+}
+
+func TestClassifyErrorReadTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	err := requestError(t, &http.Client{Timeout: 50 * time.Millisecond}, server.URL)
+	if actual := classifyError(err); actual != errorReadTimeout {
+		t.Errorf("expected %s, got %s for %v", errorReadTimeout, actual, err)
+	}
+}
+
+func TestClassifyErrorWrapped(t *testing.T) {
+	dnsError := &net.DNSError{Err: "no such host", Name: "icon.invalid", IsNotFound: true}
+	dialTimeout := &net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}
+	if actual := classifyError(fmt.Errorf("login: %w", dnsError)); actual != errorDns {
+		t.Errorf("expected %s, got %s", errorDns, actual)
+	}
+	if actual := classifyError(fmt.Errorf("login: %w", dialTimeout)); actual != errorDialTimeout {
+		t.Errorf("expected %s, got %s", errorDialTimeout, actual)
+	}
+	if actual := classifyError(fmt.Errorf("read: %w", errBodyTooLarge)); actual != errorBodyTooLarge {
+		t.Errorf("expected %s, got %s", errorBodyTooLarge, actual)
+	}
+	if actual := classifyError(errors.New("unexpected")); actual != errorOther {
+		t.Errorf("expected %s, got %s", errorOther, actual)
+	}
+}
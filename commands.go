@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Command line subcommand.
+type command struct {
+	// Words of the subcommand, like config validate.
+	name string
+	// Short description for the usage.
+	description string
+	// Registers the flags and returns the function, which runs the subcommand with the parsed flags.
+	setup func(flags *flag.FlagSet) func() error
+	// Values of the positional argument for the completion.
+	arguments []string
+	// Hidden commands are not listed in the usage.
+	hidden bool
+}
+
+// Available subcommands, the server is started if no subcommand is given.
+var commands = []*command{
+	{
+		name:        "config validate",
+		description: "Validates the configuration file without starting the server",
+		setup:       configValidateCommand,
+	},
+	{
+		name:        "config print",
+		description: "Prints the effective device configuration with the defaults and groups merged",
+		setup:       configPrintCommand,
+	},
+	{
+		name:        "init",
+		description: "Asks for the device settings and writes a new configuration file",
+		setup:       initCommand,
+	},
+	{
+		name:        "discover",
+		description: "Scans the subnet for devices and prints their urls",
+		setup:       discoverCommand,
+	},
+	{
+		name:        "probe",
+		description: "Logs in to the devices and prints the latency, firmware version and room count",
+		setup:       probeCommand,
+	},
+	{
+		name:        "dump",
+		description: "Prints the values read from the devices as a table or JSON",
+		setup:       dumpCommand,
+	},
+	{
+		name:        "top",
+		description: "Polls the devices and shows the values in a live terminal table",
+		setup:       topCommand,
+	},
+	{
+		name:        "set thermostat",
+		description: "Experimental! Sets the thermostat settings of a room",
+		setup:       setThermostatCommand,
+	},
+	{
+		name:        "set general",
+		description: "Experimental! Sets the general settings of a device",
+		setup:       setGeneralCommand,
+	},
+	{
+		name:        "healthcheck",
+		description: "Checks the liveness of the running server, for container health checks",
+		setup:       healthcheckCommand,
+	},
+}
+
+// Returns the subcommand and its arguments, nil if the arguments do not start with a subcommand.
+func findCommand(args []string) (*command, []string) {
+	cmd, remaining := matchCommand(args)
+	if cmd != nil {
+		return cmd, remaining
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n", strings.Join(args, " "))
+		printUsage(nil)
+		os.Exit(2)
+	}
+	return nil, args
+}
+
+// Returns the subcommand, which the arguments start with, and the remaining arguments.
+func matchCommand(args []string) (*command, []string) {
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) {
+			continue
+		}
+		matches := true
+		for i, word := range words {
+			if args[i] != word {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return cmd, args[len(words):]
+		}
+	}
+	return nil, args
+}
+
+// Prints the usage of the server flags and the subcommands.
+func printUsage(flags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "Usage: icon-metrics [flags]\n")
+	fmt.Fprintf(os.Stderr, "       icon-metrics <command> [flags]\n")
+	if flags != nil {
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, cmd := range commands {
+		if cmd.hidden {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.description)
+	}
+}
+
+// Creates the flags of the subcommand.
+func commandFlags(name string) *flag.FlagSet {
+	return flag.NewFlagSet("icon-metrics "+name, flag.ExitOnError)
+}
+
+// Validates the configuration file without starting the server.
+func configValidateCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	return func() error {
+		path := resolveConfigPath(*configPath)
+		c, err := config.ReadConfig(path)
+		if err != nil {
+			return err
+		}
+		warnIgnoredEnvironment(c)
+		fmt.Printf("Configuration %s is valid\n", path)
+		return nil
+	}
+}
+
+// Prints the effective configuration of the devices, the passwords are redacted.
+func configPrintCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	sysId := deviceFlag(flags)
+	return func() error {
+		c, err := readCommandConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		devices, err := selectDevices(c, *sysId)
+		if err != nil {
+			return err
+		}
+		printed := make([]*config.IconConfiguration, 0, len(devices))
+		for _, device := range devices {
+			redacted := *device
+			redacted.Password = "<redacted>"
+			printed = append(printed, &redacted)
+		}
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(map[string]any{"devices": printed})
+	}
+}
+
+// Checks the liveness of the running server.
+func healthcheckCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	rawUrl := flags.String("url", "", "Url of the health check, derived from the configuration if empty")
+	timeout := flags.Duration("timeout", 5*time.Second, "Timeout of the health check")
+	return func() error {
+		// No credentials or client certificate are sent, the health checks do not require authentication.
+		httpClient := &http.Client{
+			Timeout: *timeout,
+			Transport: &http.Transport{
+				// The server is checked by itself, so the certificate is not verified.
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+		u := *rawUrl
+		if u == "" {
+			c, err := config.ReadConfig(resolveConfigPath(*configPath))
+			if err != nil {
+				return err
+			}
+			u = healthcheckUrl(c, httpClient)
+		}
+		res, err := httpClient.Get(u)
+		if err != nil {
+			return fmt.Errorf("health check failed: %w", err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("health check failed with status code %d", res.StatusCode)
+		}
+		fmt.Println("Server is healthy")
+		return nil
+	}
+}
+
+// Returns the liveness url of the configured server, unix sockets are set as the dialer of the client.
+func healthcheckUrl(c *config.Configuration, httpClient *http.Client) string {
+	scheme := "http"
+	if c.Web != nil && c.Web.TlsServer != nil {
+		scheme = "https"
+	}
+	network, address := c.ListenAddress()
+	if network == "unix" {
+		httpClient.Transport.(*http.Transport).DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}
+		return scheme + "://localhost/healthz"
+	}
+	host, port, _ := net.SplitHostPort(address)
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + "/healthz"
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Completion scripts by shell, the scripts ask the candidates from the __complete command.
+var completionScripts = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// Completes the values of the flags by flag name, the flags are parsed from the previous arguments.
+var flagCompletions = map[string]func(flags *flag.FlagSet, current string) []string{
+	"config": completeConfigPath,
+	"device": completeDevices,
+	"room":   completeRooms,
+	"format": func(flags *flag.FlagSet, current string) []string {
+		return []string{formatTable, formatJson}
+	},
+}
+
+const bashCompletion = `# bash completion for icon-metrics, generated by icon-metrics completion bash
+
+__icon_metrics_completion() {
+    local IFS=$'\n'
+    COMPREPLY=($("${COMP_WORDS[0]}" __complete -- "${COMP_WORDS[@]:1:$COMP_CWORD}" 2>/dev/null))
+    local candidate
+    for candidate in "${COMPREPLY[@]}"
+    do
+        if [ "${candidate: -1}" = "/" ]
+        then
+            compopt -o nospace
+            break
+        fi
+    done
+}
+
+complete -F __icon_metrics_completion icon-metrics
+`
+
+const zshCompletion = `#compdef icon-metrics
+# zsh completion for icon-metrics, generated by icon-metrics completion zsh
+
+_icon_metrics() {
+    local -a candidates
+    local candidate
+    candidates=("${(@f)$("${words[1]}" __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    for candidate in "${candidates[@]}"
+    do
+        if [[ "$candidate" == */ ]]
+        then
+            compadd -Q -S '' -- "$candidate"
+        elif [[ -n "$candidate" ]]
+        then
+            compadd -Q -- "$candidate"
+        fi
+    done
+}
+
+if [ "$funcstack[1]" = "_icon_metrics" ]
+then
+    _icon_metrics "$@"
+else
+    compdef _icon_metrics icon-metrics
+fi
+`
+
+const fishCompletion = `# fish completion for icon-metrics, generated by icon-metrics completion fish
+
+function __icon_metrics_completion
+    set -l words (commandline -opc)
+    $words[1] __complete -- $words[2..-1] (commandline -ct) 2>/dev/null
+end
+
+complete -c icon-metrics -f -a '(__icon_metrics_completion)'
+`
+
+func init() {
+	// Registered here, as the completion reads the commands, which would be an initialization cycle.
+	commands = append(commands,
+		&command{
+			name:        "completion",
+			description: "Prints the completion script of the shell, either bash, zsh or fish",
+			setup:       completionCommand,
+			arguments:   []string{"bash", "fish", "zsh"},
+		},
+		&command{
+			name:        "__complete",
+			description: "Prints the completion candidates of the last argument",
+			setup:       completeCommand,
+			hidden:      true,
+		},
+	)
+}
+
+// Prints the completion script of the shell.
+func completionCommand(flags *flag.FlagSet) func() error {
+	return func() error {
+		shell := flags.Arg(0)
+		script, ok := completionScripts[shell]
+		if !ok {
+			return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+		}
+		fmt.Print(script)
+		return nil
+	}
+}
+
+// Prints the completion candidates of the last argument, one per line.
+func completeCommand(flags *flag.FlagSet) func() error {
+	return func() error {
+		for _, candidate := range complete(flags.Args()) {
+			fmt.Println(candidate)
+		}
+		return nil
+	}
+}
+
+// Returns the completion candidates of the last word, the previous words are the arguments before it.
+func complete(words []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	previous, current := words[:len(words)-1], words[len(words)-1]
+	cmd, args := matchCommand(previous)
+	if cmd == nil {
+		if len(previous) > 0 && strings.HasPrefix(previous[0], "-") || len(previous) == 0 && strings.HasPrefix(current, "-") {
+			return completeFlags(nil, previous, current)
+		}
+		return filterCandidates(completeCommandWords(previous), current)
+	}
+	return completeFlags(cmd, args, current)
+}
+
+// Returns the next words of the commands, which start with the previous words.
+func completeCommandWords(previous []string) []string {
+	candidates := []string{}
+	seen := map[string]bool{}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if cmd.hidden || len(words) <= len(previous) {
+			continue
+		}
+		matches := true
+		for i, word := range previous {
+			if words[i] != word {
+				matches = false
+				break
+			}
+		}
+		if matches && !seen[words[len(previous)]] {
+			seen[words[len(previous)]] = true
+			candidates = append(candidates, words[len(previous)])
+		}
+	}
+	return candidates
+}
+
+// Returns the flag names or the flag value candidates of the command, the server if the command is nil.
+func completeFlags(cmd *command, args []string, current string) []string {
+	flags := flag.NewFlagSet("icon-metrics", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	if cmd == nil {
+		serverFlags(flags)
+	} else {
+		cmd.setup(flags)
+	}
+	if len(args) > 0 {
+		if f := lookupFlag(flags, args[len(args)-1]); f != nil && !isBoolFlag(f) {
+			flags.Parse(args[:len(args)-1])
+			completion, ok := flagCompletions[f.Name]
+			if !ok {
+				return nil
+			}
+			return filterCandidates(completion(flags, current), current)
+		}
+	}
+	flags.Parse(args)
+	if !strings.HasPrefix(current, "-") {
+		if cmd == nil || flags.NArg() > 0 {
+			return nil
+		}
+		return filterCandidates(cmd.arguments, current)
+	}
+	candidates := []string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		if !isFlagSet(flags, f.Name) {
+			candidates = append(candidates, "--"+f.Name)
+		}
+	})
+	return filterCandidates(candidates, current)
+}
+
+// Returns the flag of the argument, nil if the argument is not a flag name.
+func lookupFlag(flags *flag.FlagSet, arg string) *flag.Flag {
+	if !strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") {
+		return nil
+	}
+	return flags.Lookup(strings.TrimLeft(arg, "-"))
+}
+
+// Returns if the flag does not require a value.
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// Returns the candidates, which start with the current word.
+func filterCandidates(candidates []string, current string) []string {
+	filtered := []string{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	return filtered
+}
+
+// Returns the directories and the yaml files starting with the current path.
+func completeConfigPath(flags *flag.FlagSet, current string) []string {
+	dir, prefix := filepath.Split(current)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	candidates := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			candidates = append(candidates, dir+name+"/")
+		} else if ext := filepath.Ext(name); ext == ".yml" || ext == ".yaml" {
+			candidates = append(candidates, dir+name)
+		}
+	}
+	return candidates
+}
+
+// Returns the system IDs of the configured devices.
+func completeDevices(flags *flag.FlagSet, current string) []string {
+	candidates := []string{}
+	for _, device := range completionDevices(flags) {
+		candidates = append(candidates, device.SysId)
+	}
+	return candidates
+}
+
+// Returns the configured room ids of the selected device, or every device if none is selected.
+func completeRooms(flags *flag.FlagSet, current string) []string {
+	seen := map[string]bool{}
+	for _, device := range completionDevices(flags) {
+		for _, room := range device.Rooms {
+			if room.Id != "" {
+				seen[room.Id] = true
+			}
+		}
+		for id := range device.RoomLabels {
+			seen[id] = true
+		}
+	}
+	candidates := []string{}
+	for id := range seen {
+		candidates = append(candidates, id)
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// Returns the devices of the configuration file set by the flags, filtered by the device flag.
+// The configuration is not validated, so the devices can be completed in an incomplete configuration too.
+func completionDevices(flags *flag.FlagSet) []*config.IconConfiguration {
+	path := ""
+	if f := flags.Lookup("config"); f != nil {
+		path = f.Value.String()
+	}
+	data, err := os.ReadFile(resolveConfigPath(path))
+	if err != nil {
+		return nil
+	}
+	c := &config.Configuration{}
+	if yaml.Unmarshal(data, c) != nil {
+		return nil
+	}
+	sysId := ""
+	if f := flags.Lookup("device"); f != nil {
+		sysId = f.Value.String()
+	}
+	devices := []*config.IconConfiguration{}
+	for _, device := range c.Devices {
+		if device != nil && device.SysId != "" && (sysId == "" || device.SysId == sysId) {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
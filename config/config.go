@@ -1,26 +1,167 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strings"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 // Configuration root
 type Configuration struct {
-	Port    int                  `yaml:"port"`
-	Devices []*IconConfiguration `yaml:"devices"`
+	Port int `yaml:"port"`
+	// Host to listen on, or unix: and the path of a unix socket.
+	Address string `yaml:"address"`
+	// Exporter toolkit compatible web configuration file.
+	WebConfigFile string `yaml:"webConfigFile"`
+	// Web configuration read from the web configuration file, nil if not set.
+	Web *WebConfiguration `yaml:"-"`
+	// Deadline in seconds of the graceful shutdown.
+	ShutdownTimeout int                         `yaml:"shutdownTimeout"`
+	Compatibility   *CompatibilityConfiguration `yaml:"compatibility"`
+	Collectors      *CollectorsConfiguration    `yaml:"collectors"`
+	HttpClient      *HttpClientConfiguration    `yaml:"httpClient"`
+	Log             *LogConfiguration           `yaml:"log"`
+	Health          *HealthConfiguration        `yaml:"health"`
+	Admin           *AdminConfiguration         `yaml:"admin"`
+	Probe           *ProbeConfiguration         `yaml:"probe"`
+	Discovery       *DiscoveryConfiguration     `yaml:"discovery"`
+	// Settings of every device and probe module, unless set by the device or its group.
+	Defaults *IconConfiguration `yaml:"defaults"`
+	// Named settings the devices and probe modules can reference.
+	Groups  map[string]*IconConfiguration `yaml:"groups"`
+	Devices []*IconConfiguration          `yaml:"devices"`
+	// Probe modules by name, url and sysid are set from the probe request.
+	Modules map[string]*IconConfiguration `yaml:"modules"`
+	// ICON_METRICS_ prefixed environment variables, which do not match a setting.
+	IgnoredEnvironment []string `yaml:"-"`
 }
 
+// Backwards compatibility configuration
+type CompatibilityConfiguration struct {
+	// Adds the room name label to all room metrics instead of icon_room_info only, enabled by default.
+	RoomNameLabel *bool `yaml:"roomNameLabel"`
+	// Reports the uptime metric in milliseconds.
+	Uptime *bool `yaml:"uptime"`
+}
+
+// Standard prometheus collectors configuration
+type CollectorsConfiguration struct {
+	// Go runtime metrics
+	Go *bool `yaml:"go"`
+	// Process metrics
+	Process *bool `yaml:"process"`
+	// Go build info metric
+	BuildInfo *bool `yaml:"buildInfo"`
+}
+
+// HTTP client metrics configuration
+type HttpClientConfiguration struct {
+	// Histogram bucket upper bounds in seconds.
+	Buckets []float64 `yaml:"buckets"`
+	// Reports native histograms along with the classic buckets.
+	NativeHistogram *bool `yaml:"nativeHistogram"`
+}
+
+// Log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// Log outputs, any other value is a file path.
+const (
+	LogOutputStdout = "stdout"
+	LogOutputStderr = "stderr"
+)
+
+// Logging configuration
+type LogConfiguration struct {
+	// Minimum level, either debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format, either text or json.
+	Format string `yaml:"format"`
+	// Destination, either stdout, stderr or a file path.
+	Output string `yaml:"output"`
+}
+
+// Readiness criteria.
+const (
+	// Ready if at least one device is fresh.
+	ReadyAny = "any"
+	// Ready if every device is fresh.
+	ReadyAll = "all"
+)
+
+// Health check configuration
+type HealthConfiguration struct {
+	// Readiness criteria, either any or all.
+	Ready string `yaml:"ready"`
+	// Duration in seconds a device is fresh for since the last successful read.
+	MaxAge int `yaml:"maxAge"`
+}
+
+// Admin http server configuration
+type AdminConfiguration struct {
+	// Port to listen on, the admin server is disabled if not set.
+	Port int `yaml:"port"`
+	// Host to listen on, or unix: and the path of a unix socket.
+	Address string `yaml:"address"`
+}
+
+// Multi-target probe configuration
+type ProbeConfiguration struct {
+	// Serves the /probe endpoint.
+	Enabled *bool `yaml:"enabled"`
+}
+
+// Polling modes.
+const (
+	// Reads the device periodically with delay between the reads.
+	ModePoll = "poll"
+	// Reads the device when the metrics are scraped.
+	ModeScrape = "scrape"
+)
+
 // iCON device configuration
 type IconConfiguration struct {
-	Url      string               `yaml:"url"`
-	SysId    string               `yaml:"sysid"`
-	Password string               `yaml:"password"`
-	Delay    int                  `yaml:"delay"`
-	Report   *ReportConfiguration `yaml:"report"`
+	Url   string `yaml:"url"`
+	SysId string `yaml:"sysid"`
+	// Name of the group the unset settings are taken from.
+	Group    string `yaml:"group"`
+	Password string `yaml:"password"`
+	// File to read the password from instead of the password setting.
+	PasswordFile string `yaml:"passwordFile"`
+	Delay        *int   `yaml:"delay"`
+	// Polling mode, either poll or scrape.
+	Mode string `yaml:"mode"`
+	// Minimum interval in seconds between reads in scrape mode.
+	MinInterval *int                 `yaml:"minInterval"`
+	Report      *ReportConfiguration `yaml:"report"`
+	Hold        *HoldConfiguration   `yaml:"hold"`
+	Rooms       []*RoomConfiguration `yaml:"rooms"`
+	// Static labels added to every series of the device.
+	Labels map[string]string `yaml:"labels"`
+	// Static labels added to the room series by room id.
+	RoomLabels map[string]map[string]string `yaml:"roomLabels"`
+	// Host glob patterns of the urls the probe module can read, only set by the probe modules.
+	Targets []string `yaml:"targets,omitempty"`
+}
+
+// Hold last value policy configuration
+type HoldConfiguration struct {
+	// Number of consecutive failures the last values are kept for.
+	Failures *int `yaml:"failures"`
+	// Duration in seconds the last values are kept for since the last successful read.
+	Duration *int `yaml:"duration"`
+	// Duration in seconds the controller has to be disconnected before it is reported.
+	ConnectedDebounce *int `yaml:"connectedDebounce"`
 }
 
 // iCON device report configuration
@@ -37,28 +178,45 @@ type ReportConfiguration struct {
 	Heating *bool `yaml:"heating"`
 	// metrics.EcoGauge
 	Eco *bool `yaml:"eco"`
-	// metrics.RoomConntectedGauge
-	RoomConnected *bool `yaml:"roomConnected"`
-	// metrics.RoomTemperatureGauge
-	Temperature *bool `yaml:"temperature"`
-	// metrics.RoomDewTemperatureGauge
-	DewTemperature *bool `yaml:"dewTemperature"`
-	// metrics.RelayGauge
-	Relay *bool `yaml:"relay"`
-	// metrics.HumidityGauge
-	Humidity *bool `yaml:"humidity"`
-	// metrics.TargetTemperatureGauge
-	TargetTemperature *bool `yaml:"targetTemperature"`
+	// metrics.RoomChangesCounter
+	RoomChanges *bool `yaml:"roomChanges"`
+	// metrics.HealthMetricsReporter
+	Health *bool `yaml:"health"`
+	// Room metrics
+	RoomReportConfiguration `yaml:",inline"`
 }
 
 // Returns the config that is read from the file.
+// Schema violations are reported together, each as a PositionError.
 func ReadConfig(filepath string) (*Configuration, error) {
-	config := &Configuration{}
 	data, err := os.ReadFile(filepath)
 	if err != nil {
-		return config, fmt.Errorf("failed to read config: %w", err)
+		return &Configuration{}, fmt.Errorf("failed to read config: %w", err)
 	}
-	err = yaml.Unmarshal(data, &config)
+	return ParseConfig(data)
+}
+
+// Returns the config that is parsed from the yaml content.
+// Schema violations are reported together, each as a PositionError.
+func ParseConfig(data []byte) (*Configuration, error) {
+	config := &Configuration{}
+	document := &yaml.Node{}
+	err := yaml.Unmarshal(data, document)
+	if err != nil {
+		return config, fmt.Errorf("failed to parse yaml: %w", err)
+	}
+	sources, ignored, err := applyEnvironment(document, os.Environ())
+	if err != nil {
+		return config, fmt.Errorf("failed to apply environment: %w", err)
+	}
+	errs := interpolate(document, sources)
+	if len(errs) == 0 {
+		errs = validateSchema(document, sources)
+	}
+	if len(errs) != 0 {
+		return config, fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+	err = decodeStrict(document, config)
 	if err != nil {
 		return config, fmt.Errorf("failed to parse yaml: %w", err)
 	}
@@ -66,89 +224,261 @@ func ReadConfig(filepath string) (*Configuration, error) {
 	if err != nil {
 		return config, fmt.Errorf("invalid config: %w", err)
 	}
+	config.IgnoredEnvironment = ignored
 	return config, nil
 }
 
+// Decodes the document, unknown settings are rejected.
+func decodeStrict(document *yaml.Node, config *Configuration) error {
+	data, err := yaml.Marshal(document)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	return decoder.Decode(config)
+}
+
 // Scans the config for invalid settings.
 func validateConfig(config *Configuration) error {
 	if config.Port == 0 {
 		config.Port = 80
 	}
-	if config.Devices == nil || len(config.Devices) == 0 {
+	if config.WebConfigFile != "" {
+		web, err := ReadWebConfig(config.WebConfigFile)
+		if err != nil {
+			return err
+		}
+		config.Web = web
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 5
+	}
+	if config.Compatibility == nil {
+		config.Compatibility = &CompatibilityConfiguration{}
+	}
+	if config.Compatibility.RoomNameLabel == nil {
+		config.Compatibility.RoomNameLabel = enabled()
+	}
+	if config.Compatibility.Uptime == nil {
+		config.Compatibility.Uptime = disabled()
+	}
+	if config.Collectors == nil {
+		config.Collectors = &CollectorsConfiguration{}
+	}
+	if config.Collectors.Go == nil {
+		config.Collectors.Go = disabled()
+	}
+	if config.Collectors.Process == nil {
+		config.Collectors.Process = disabled()
+	}
+	if config.Collectors.BuildInfo == nil {
+		config.Collectors.BuildInfo = disabled()
+	}
+	if config.HttpClient == nil {
+		config.HttpClient = &HttpClientConfiguration{}
+	}
+	if len(config.HttpClient.Buckets) == 0 {
+		config.HttpClient.Buckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	}
+	if !slices.IsSorted(config.HttpClient.Buckets) {
+		return errors.New("httpClient buckets must be in increasing order")
+	}
+	if config.HttpClient.NativeHistogram == nil {
+		config.HttpClient.NativeHistogram = disabled()
+	}
+	if config.Log == nil {
+		config.Log = &LogConfiguration{}
+	}
+	if config.Log.Level == "" {
+		config.Log.Level = "info"
+	}
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, config.Log.Level) {
+		return fmt.Errorf("invalid log level %s", config.Log.Level)
+	}
+	if config.Log.Format == "" {
+		config.Log.Format = LogFormatText
+	}
+	if config.Log.Format != LogFormatText && config.Log.Format != LogFormatJson {
+		return fmt.Errorf("invalid log format %s", config.Log.Format)
+	}
+	if config.Log.Output == "" {
+		config.Log.Output = LogOutputStderr
+	}
+	if config.Health == nil {
+		config.Health = &HealthConfiguration{}
+	}
+	if config.Health.Ready == "" {
+		config.Health.Ready = ReadyAny
+	}
+	if config.Health.Ready != ReadyAny && config.Health.Ready != ReadyAll {
+		return fmt.Errorf("invalid health ready criteria %s", config.Health.Ready)
+	}
+	if config.Health.MaxAge == 0 {
+		config.Health.MaxAge = 60
+	}
+	if config.Admin == nil {
+		config.Admin = &AdminConfiguration{}
+	}
+	if config.Admin.Address == "" {
+		config.Admin.Address = "127.0.0.1"
+	}
+	if config.Probe == nil {
+		config.Probe = &ProbeConfiguration{}
+	}
+	if config.Probe.Enabled == nil {
+		config.Probe.Enabled = disabled()
+	}
+	err := validateDiscovery(config)
+	if err != nil {
+		return err
+	}
+	if len(config.Devices) == 0 && len(config.Modules) == 0 && !config.Discovery.Enabled() {
 		return errors.New("there are no devices to monitor")
 	}
+	err = validateInheritance(config)
+	if err != nil {
+		return err
+	}
+	err = validateLabels(config)
+	if err != nil {
+		return err
+	}
+	sysIds := make(map[string]bool)
 	for i, device := range config.Devices {
 		if device.SysId == "" {
 			return fmt.Errorf("device config at %d position is missing sysid", i)
 		}
+		if sysIds[device.SysId] {
+			return fmt.Errorf("device config at %d position has duplicate sysid %s", i, device.SysId)
+		}
+		sysIds[device.SysId] = true
 		if device.Url == "" {
 			return fmt.Errorf("device config at %d position is missing url", i)
 		}
-		if device.Password == "" {
-			device.Password = device.SysId
-		}
-		if device.Delay == 0 {
-			device.Delay = 15
-		}
-		if device.Report == nil {
-			defaultReport := ReportConfiguration{
-				ControllerConnected: enabled(),
-				HttpClient:          enabled(),
-				WaterTemperature:    enabled(),
-				ExternalTemperature: enabled(),
-				Heating:             enabled(),
-				Eco:                 enabled(),
-				RoomConnected:       enabled(),
-				Temperature:         enabled(),
-				DewTemperature:      enabled(),
-				Relay:               enabled(),
-				Humidity:            enabled(),
-				TargetTemperature:   enabled(),
-			}
-			device.Report = &defaultReport
-		} else {
-			if device.Report.ControllerConnected == nil {
-				device.Report.ControllerConnected = enabled()
-			}
-			if device.Report.HttpClient == nil {
-				device.Report.HttpClient = enabled()
-			}
-			if device.Report.WaterTemperature == nil {
-				device.Report.WaterTemperature = enabled()
-			}
-			if device.Report.ExternalTemperature == nil {
-				device.Report.ExternalTemperature = enabled()
-			}
-			if device.Report.Heating == nil {
-				device.Report.Heating = enabled()
-			}
-			if device.Report.Eco == nil {
-				device.Report.Eco = enabled()
-			}
-			if device.Report.RoomConnected == nil {
-				device.Report.RoomConnected = enabled()
-			}
-			if device.Report.Temperature == nil {
-				device.Report.Temperature = enabled()
-			}
-			if device.Report.DewTemperature == nil {
-				device.Report.DewTemperature = enabled()
-			}
-			if device.Report.Relay == nil {
-				device.Report.Relay = enabled()
-			}
-			if device.Report.Humidity == nil {
-				device.Report.Humidity = enabled()
-			}
-			if device.Report.TargetTemperature == nil {
-				device.Report.TargetTemperature = enabled()
+		err := validateDevice(device, fmt.Sprintf("device config at %d position", i))
+		if err != nil {
+			return err
+		}
+	}
+	for name, module := range config.Modules {
+		err := validateDevice(module, fmt.Sprintf("module config %s", name))
+		if err != nil {
+			return err
+		}
+		for _, target := range module.Targets {
+			_, err := path.Match(target, "")
+			if err != nil {
+				return fmt.Errorf("module config %s has invalid target pattern %s: %w", name, target, err)
 			}
 		}
 	}
 	return nil
 }
 
+// Checks the defaults and the groups, and merges them into the devices and the probe modules.
+func validateInheritance(config *Configuration) error {
+	if config.Defaults != nil {
+		err := validateTemplate(config.Defaults, "defaults")
+		if err != nil {
+			return err
+		}
+	}
+	for name, group := range config.Groups {
+		err := validateTemplate(group, fmt.Sprintf("group config %s", name))
+		if err != nil {
+			return err
+		}
+	}
+	for i, device := range config.Devices {
+		if len(device.Targets) != 0 {
+			return fmt.Errorf("device config at %d position cannot set targets", i)
+		}
+		err := config.inherit(device, fmt.Sprintf("device config at %d position", i))
+		if err != nil {
+			return err
+		}
+	}
+	for name, module := range config.Modules {
+		err := config.inherit(module, fmt.Sprintf("module config %s", name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scans the device config for invalid settings and sets the defaults.
+func validateDevice(device *IconConfiguration, name string) error {
+	if device.PasswordFile != "" {
+		if device.Password != "" {
+			return fmt.Errorf("%s has both password and passwordFile", name)
+		}
+		data, err := os.ReadFile(device.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("%s has unreadable passwordFile: %w", name, err)
+		}
+		device.Password = strings.TrimRight(string(data), "\r\n")
+	}
+	if device.Password == "" {
+		device.Password = device.SysId
+	}
+	merge(device, builtinDevice())
+	if device.Mode != ModePoll && device.Mode != ModeScrape {
+		return fmt.Errorf("%s has invalid mode %s", name, device.Mode)
+	}
+	if *device.Hold.Failures < 0 || *device.Hold.Duration < 0 || *device.Hold.ConnectedDebounce < 0 {
+		return fmt.Errorf("%s has negative hold values", name)
+	}
+	for j, room := range device.Rooms {
+		err := room.validate()
+		if err != nil {
+			return fmt.Errorf("%s has invalid room config at %d position: %w", name, j, err)
+		}
+	}
+	return nil
+}
+
+// Returns if the probe module can read the device at the url, the host of the url has to match one of the targets.
+func (module *IconConfiguration) AllowsTarget(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	for _, pattern := range module.Targets {
+		if matchesHost(pattern, u.Host) || matchesHost(pattern, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns if the host matches the glob pattern label by label, so a wildcard cannot match the dots of the host.
+func matchesHost(pattern string, host string) bool {
+	patternLabels := strings.Split(pattern, ".")
+	hostLabels := strings.Split(host, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+	for i, label := range patternLabels {
+		matched, err := path.Match(label, hostLabels[i])
+		if err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
 func enabled() *bool {
 	b := true
 	return &b
 }
+
+func disabled() *bool {
+	b := false
+	return &b
+}
+
+func integer(n int) *int {
+	return &n
+}
@@ -0,0 +1,56 @@
+package config
+
+import "testing"
+
+func TestAllowsTarget(t *testing.T) {
+	module := &IconConfiguration{Targets: []string{"192.168.1.*", "icon.local:8080", "*.home.arpa"}}
+	allowed := map[string]bool{
+		"http://192.168.1.10":            true,
+		"https://192.168.1.10:443/path":  true,
+		"http://192.168.2.10":            false,
+		"http://icon.local:8080":         true,
+		"http://icon.local":              false,
+		"http://icon.local:9090":         false,
+		"http://upstairs.home.arpa":      true,
+		"http://home.arpa":               false,
+		"ftp://192.168.1.10":             false,
+		"192.168.1.10":                   false,
+		"http://user@192.168.2.10@evil":  false,
+		"http://192.168.1.10.evil.com":   false,
+		"http://192.168.1.10%2F@evil":    false,
+		"http://[fd00::1]":               false,
+		"http://evil.com/192.168.1.10":   false,
+		"http://evil.com?192.168.1.10":   false,
+		"http://evil.com#192.168.1.10":   false,
+		"http://192.168.1.10@evil.com":   false,
+		"http://evil.com\\@192.168.1.10": false,
+	}
+	for target, expected := range allowed {
+		if actual := module.AllowsTarget(target); actual != expected {
+			t.Errorf("expected %t for %s, got %t", expected, target, actual)
+		}
+	}
+	if (&IconConfiguration{}).AllowsTarget("http://192.168.1.10") {
+		t.Error("module without targets allows every target")
+	}
+}
+
+func TestModuleTargetsValidation(t *testing.T) {
+	_, err := ParseConfig([]byte(`
+modules:
+  default:
+    targets: ['[invalid']
+`))
+	if err == nil {
+		t.Error("invalid target pattern is accepted")
+	}
+	_, err = ParseConfig([]byte(`
+devices:
+  - url: http://192.168.1.10
+    sysid: '123456789012'
+    targets: ['192.168.1.*']
+`))
+	if err == nil {
+		t.Error("device with targets is accepted")
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Valid sysid of the discovered devices.
+var sysIdPattern = regexp.MustCompile(`^\d{12}$`)
+
+// Service discovery configuration
+type DiscoveryConfiguration struct {
+	// Files or glob patterns of the device entries, every yaml and json file is read from directories.
+	Files []string `yaml:"files"`
+	// Url to read the device entries from.
+	Url string `yaml:"url"`
+	// Interval in seconds between the reads of the files and the url.
+	RefreshInterval int `yaml:"refreshInterval"`
+	// Probe module used as the configuration of the discovered devices.
+	Module string `yaml:"module"`
+	// Label names the discovered devices can set.
+	LabelNames []string `yaml:"labelNames"`
+}
+
+// Device entry of the service discovery
+type DiscoveryEntry struct {
+	Url      string `yaml:"url"`
+	SysId    string `yaml:"sysid"`
+	Password string `yaml:"password"`
+	// File to read the password from instead of the password setting.
+	PasswordFile string `yaml:"passwordFile"`
+	// Static labels added to every series of the device.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// Returns if the devices are discovered from files or url.
+func (discovery *DiscoveryConfiguration) Enabled() bool {
+	return len(discovery.Files) != 0 || discovery.Url != ""
+}
+
+// Returns the device entries parsed from the yaml or json content, unknown settings are rejected.
+func ParseDiscoveryEntries(data []byte) ([]*DiscoveryEntry, error) {
+	entries := make([]*DiscoveryEntry, 0)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&entries)
+	if err != nil && len(bytes.TrimSpace(data)) != 0 {
+		return nil, fmt.Errorf("failed to parse device entries: %w", err)
+	}
+	return entries, nil
+}
+
+// Returns the configuration of the discovered device, the settings not set by the entry are taken from the discovery module.
+// Remote entries cannot set a password file, so the discovery url cannot read the files of the exporter.
+func (config *Configuration) DiscoveredDevice(entry *DiscoveryEntry, remote bool) (*IconConfiguration, error) {
+	if !sysIdPattern.MatchString(entry.SysId) {
+		return nil, fmt.Errorf("invalid sysid %q", entry.SysId)
+	}
+	if !strings.HasPrefix(entry.Url, "http://") && !strings.HasPrefix(entry.Url, "https://") {
+		return nil, fmt.Errorf("device %s has invalid url %q", entry.SysId, entry.Url)
+	}
+	if remote && entry.PasswordFile != "" {
+		return nil, fmt.Errorf("device %s sets passwordFile, which is only accepted from files", entry.SysId)
+	}
+	device := &IconConfiguration{}
+	module, ok := config.Modules[config.Discovery.Module]
+	if !ok {
+		config.inherit(device, "discovered device "+entry.SysId)
+	} else {
+		// The password of the module must not be sent to other hosts than its targets.
+		if !module.AllowsTarget(entry.Url) {
+			return nil, fmt.Errorf("device %s url %q does not match the targets of module %s", entry.SysId, entry.Url, config.Discovery.Module)
+		}
+		// The module is deep copied, so the rooms, the hold and the report settings are not shared with the module.
+		merge(device, module)
+		// The password of the module is already read from its file.
+		device.PasswordFile = ""
+		device.Targets = nil
+	}
+	device.Url = entry.Url
+	device.SysId = entry.SysId
+	if entry.Password != "" || entry.PasswordFile != "" {
+		device.Password = entry.Password
+		device.PasswordFile = entry.PasswordFile
+	}
+	device.Labels = maps.Clone(device.Labels)
+	if device.Labels == nil {
+		device.Labels = make(map[string]string)
+	}
+	labelNames := config.DeviceLabelNames()
+	for name, value := range entry.Labels {
+		if !slices.Contains(labelNames, name) {
+			return nil, fmt.Errorf("device %s has undeclared label %s", entry.SysId, name)
+		}
+		device.Labels[name] = value
+	}
+	err := validateDevice(device, "discovered device "+entry.SysId)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// Scans the discovery config for invalid settings and sets the defaults.
+func validateDiscovery(config *Configuration) error {
+	if config.Discovery == nil {
+		config.Discovery = &DiscoveryConfiguration{}
+	}
+	if config.Discovery.RefreshInterval == 0 {
+		config.Discovery.RefreshInterval = 30
+	}
+	if config.Discovery.Module != "" {
+		module, ok := config.Modules[config.Discovery.Module]
+		if !ok {
+			return fmt.Errorf("discovery module %s is not configured", config.Discovery.Module)
+		}
+		if len(module.Targets) == 0 {
+			return fmt.Errorf("discovery module %s has no targets, every discovered device would be rejected", config.Discovery.Module)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const discoveryConfig = `
+discovery:
+  url: http://sd.example.com/icon-devices
+  module: default
+  labelNames: [site]
+modules:
+  default:
+    targets: ['192.168.1.*']
+    password: module-secret
+    delay: 30
+    labels:
+      env: home
+`
+
+func TestParseDiscoveryEntries(t *testing.T) {
+	entries, err := ParseDiscoveryEntries([]byte(`[{"url": "http://192.168.1.10", "sysid": "123456789012", "labels": {"site": "a"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].SysId != "123456789012" || entries[0].Labels["site"] != "a" {
+		t.Errorf("json entries are not parsed, got %+v", entries)
+	}
+
+	entries, err = ParseDiscoveryEntries([]byte("  \n"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("empty content is not an empty list, got %v %v", entries, err)
+	}
+
+	_, err = ParseDiscoveryEntries([]byte("- url: http://192.168.1.10\n  sysid: '123456789012'\n  delay: 10\n"))
+	if err == nil {
+		t.Error("entry with unknown setting is accepted")
+	}
+}
+
+func TestDiscoveredDevice(t *testing.T) {
+	c, err := ParseConfig([]byte(discoveryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device, err := c.DiscoveredDevice(&DiscoveryEntry{Url: "http://192.168.1.10", SysId: "123456789012", Labels: map[string]string{"site": "a"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Password != "module-secret" || *device.Delay != 30 || device.Targets != nil {
+		t.Errorf("module settings are not inherited, got %+v", device)
+	}
+	if device.Labels["site"] != "a" || device.Labels["env"] != "home" {
+		t.Errorf("labels are not merged, got %v", device.Labels)
+	}
+	if _, ok := c.Modules["default"].Labels["site"]; ok {
+		t.Error("labels of the entry are written to the module")
+	}
+
+	passwordFile := filepath.Join(t.TempDir(), "site-b")
+	err = os.WriteFile(passwordFile, []byte("site-secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err = c.DiscoveredDevice(&DiscoveryEntry{Url: "http://192.168.1.11", SysId: "210987654321", PasswordFile: passwordFile}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Password != "site-secret" {
+		t.Errorf("password file of the file entry is not used, got %q", device.Password)
+	}
+	_, err = c.DiscoveredDevice(&DiscoveryEntry{Url: "http://192.168.1.11", SysId: "210987654321", PasswordFile: passwordFile}, true)
+	if err == nil || !strings.Contains(err.Error(), "passwordFile") {
+		t.Errorf("password file of the url entry is accepted, got %v", err)
+	}
+}
+
+func TestDiscoveredDeviceRejected(t *testing.T) {
+	c, err := ParseConfig([]byte(discoveryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for reason, entry := range map[string]*DiscoveryEntry{
+		"sysid":   {Url: "http://192.168.1.10", SysId: "1234"},
+		"url":     {Url: "ftp://192.168.1.10", SysId: "123456789012"},
+		"targets": {Url: "http://192.168.1.10.evil.example", SysId: "123456789012"},
+		"label":   {Url: "http://192.168.1.10", SysId: "123456789012", Labels: map[string]string{"room": "a"}},
+	} {
+		_, err := c.DiscoveredDevice(entry, true)
+		if err == nil {
+			t.Errorf("remote entry with invalid %s is accepted", reason)
+		}
+	}
+}
+
+func TestDiscoveryModuleWithoutTargets(t *testing.T) {
+	_, err := ParseConfig([]byte(strings.Replace(discoveryConfig, "targets: ['192.168.1.*']", "sysid: '123456789012'", 1)))
+	if err == nil {
+		t.Error("discovery module without targets is accepted")
+	}
+}
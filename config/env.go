@@ -0,0 +1,335 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables overriding the configuration.
+// It is specific to the exporter, so the variables of other services, like the Kubernetes service links, are not applied.
+const envPrefix = "ICON_METRICS_"
+
+// Matches the ${NAME} environment variable references and the $${NAME} escaped literals.
+var envReferencePattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Overrides the configuration document from the ICON_METRICS_ prefixed environment variables.
+// The name is the path of the setting in upper snake case, like ICON_METRICS_DEVICES_0_PASSWORD.
+// Returns the environment variable names of the overridden nodes,
+// and the unknown names, which are not referenced by the document either.
+func applyEnvironment(document *yaml.Node, environ []string) (map[*yaml.Node]string, []string, error) {
+	names := make([]string, 0)
+	values := make(map[string]string)
+	for _, env := range environ {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		names = append(names, name)
+		values[name] = value
+	}
+	sort.Strings(names)
+	sources := make(map[*yaml.Node]string)
+	ignored := make([]string, 0)
+	references := envReferences(document, make(map[string]bool))
+	root := documentRoot(document)
+	configurationType := reflect.TypeOf(Configuration{})
+	for _, name := range names {
+		path := strings.TrimPrefix(name, envPrefix)
+		if !envPathExists(configurationType, path) {
+			if !references[name] {
+				ignored = append(ignored, name)
+			}
+			continue
+		}
+		node, err := setEnvNode(root, configurationType, path, values[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid environment variable %s: %w", name, err)
+		}
+		markSource(sources, node, name)
+	}
+	return sources, ignored, nil
+}
+
+// Sets the value of the setting at the path, returns the updated node or nil if the path does not exist.
+func setEnvNode(node *yaml.Node, t reflect.Type, path string, value string) (*yaml.Node, error) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key, inline := yamlKey(field)
+			if key == "-" {
+				continue
+			}
+			if inline {
+				if envPathExists(field.Type, path) {
+					return setEnvNode(node, field.Type, path, value)
+				}
+				continue
+			}
+			name := envName(key)
+			if path == name {
+				return setEnvLeaf(mappingValue(node, key), field.Type, value)
+			}
+			// Only the existing paths are followed, so the nodes of the similarly named settings are not created.
+			if rest, ok := strings.CutPrefix(path, name+"_"); ok && envPathExists(field.Type, rest) {
+				return setEnvNode(mappingValue(node, key), field.Type, rest, value)
+			}
+		}
+		return nil, nil
+	case reflect.Slice:
+		index, rest, _ := strings.Cut(path, "_")
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 {
+			return nil, nil
+		}
+		if node.Kind != yaml.SequenceNode {
+			*node = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		}
+		for len(node.Content) <= i {
+			node.Content = append(node.Content, nullNode())
+		}
+		if rest == "" {
+			return setEnvLeaf(node.Content[i], t.Elem(), value)
+		}
+		return setEnvNode(node.Content[i], t.Elem(), rest, value)
+	case reflect.Map:
+		key, rest := mapKey(node, t.Elem(), path)
+		if rest == "" {
+			return setEnvLeaf(mappingValue(node, key), t.Elem(), value)
+		}
+		return setEnvNode(mappingValue(node, key), t.Elem(), rest, value)
+	}
+	return nil, nil
+}
+
+// Returns if the path is a setting of the type, the map keys can be any name.
+func envPathExists(t reflect.Type, path string) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key, inline := yamlKey(field)
+			if key == "-" {
+				continue
+			}
+			if inline {
+				if envPathExists(field.Type, path) {
+					return true
+				}
+				continue
+			}
+			name := envName(key)
+			if path == name {
+				return true
+			}
+			if rest, ok := strings.CutPrefix(path, name+"_"); ok && envPathExists(field.Type, rest) {
+				return true
+			}
+		}
+	case reflect.Slice:
+		index, rest, _ := strings.Cut(path, "_")
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 {
+			return false
+		}
+		return rest == "" || envPathExists(t.Elem(), rest)
+	case reflect.Map:
+		if path == "" {
+			return false
+		}
+		// The map key can contain underscores, so every split is checked.
+		for i, r := range path {
+			if r == '_' && envPathExists(t.Elem(), path[i+1:]) {
+				return true
+			}
+		}
+		return !strings.Contains(path, "_") || t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// Returns the map key and the remaining path, existing keys are matched case insensitively.
+// New keys end at the first underscore, after which the remaining path is a setting of the map value.
+func mapKey(node *yaml.Node, t reflect.Type, path string) (string, string) {
+	leaf := t.Kind() == reflect.String
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			name := strings.ToUpper(key)
+			if path == name {
+				return key, ""
+			}
+			if rest, ok := strings.CutPrefix(path, name+"_"); ok && !leaf && envPathExists(t, rest) {
+				return key, rest
+			}
+		}
+	}
+	if leaf {
+		return strings.ToLower(path), ""
+	}
+	for i, r := range path {
+		if r == '_' && envPathExists(t, path[i+1:]) {
+			return strings.ToLower(path[:i]), path[i+1:]
+		}
+	}
+	return strings.ToLower(path), ""
+}
+
+// Replaces the node with the parsed value, strings are not parsed.
+func setEnvLeaf(node *yaml.Node, t reflect.Type, value string) (*yaml.Node, error) {
+	if t.Kind() == reflect.String {
+		*node = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		return node, nil
+	}
+	parsed := &yaml.Node{}
+	err := yaml.Unmarshal([]byte(value), parsed)
+	if err != nil {
+		return nil, err
+	}
+	*node = *documentRoot(parsed)
+	return node, nil
+}
+
+// Returns the value node of the key, the node is converted to a mapping and the key is added if missing.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	value := nullNode()
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
+
+// Returns the root content node of the document, an empty document is converted to a null node.
+func documentRoot(document *yaml.Node) *yaml.Node {
+	if document.Kind != yaml.DocumentNode {
+		*document = yaml.Node{Kind: yaml.DocumentNode}
+	}
+	if len(document.Content) == 0 {
+		document.Content = append(document.Content, nullNode())
+	}
+	return document.Content[0]
+}
+
+// Returns a new null node.
+func nullNode() *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+}
+
+// Sets the source of the node and its children.
+func markSource(sources map[*yaml.Node]string, node *yaml.Node, name string) {
+	sources[node] = name
+	for _, child := range node.Content {
+		markSource(sources, child, name)
+	}
+}
+
+// Returns the YAML key of the field and if the field is inlined.
+func yamlKey(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("yaml")
+	key, options, _ := strings.Cut(tag, ",")
+	if options == "inline" {
+		return "", true
+	}
+	if key == "" {
+		key = strings.ToLower(field.Name)
+	}
+	return key, false
+}
+
+// Returns the environment variable name of the YAML key in upper snake case.
+func envName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// Adds the environment variable names referenced with the ${NAME} syntax in the scalar values.
+func envReferences(node *yaml.Node, references map[string]bool) map[string]bool {
+	for _, match := range envReferencePattern.FindAllStringSubmatch(node.Value, -1) {
+		if !strings.HasPrefix(match[0], "$$") {
+			references[match[1]] = true
+		}
+	}
+	for _, child := range node.Content {
+		envReferences(child, references)
+	}
+	return references
+}
+
+// Replaces the ${NAME} references with the environment variable values in every scalar value.
+func interpolate(node *yaml.Node, sources map[*yaml.Node]string) []error {
+	errs := make([]error, 0)
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			errs = append(errs, interpolate(child, sources)...)
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, interpolate(node.Content[i], sources)...)
+		}
+	case yaml.ScalarNode:
+		if !envReferencePattern.MatchString(node.Value) {
+			break
+		}
+		value, err := expandEnv(node.Value)
+		if err != nil {
+			errs = append(errs, newPositionError(node, sources, "", err))
+			break
+		}
+		node.Value = value
+		if node.Style == 0 {
+			// Plain values are resolved again, so numbers and booleans can be referenced.
+			node.Tag = ""
+		}
+	}
+	return errs
+}
+
+// Replaces the ${NAME} references with the environment variable values, and the $${NAME} literals with ${NAME}.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envReferencePattern.ReplaceAllStringFunc(s, func(reference string) string {
+		if strings.HasPrefix(reference, "$$") {
+			return reference[1:]
+		}
+		name := envReferencePattern.FindStringSubmatch(reference)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	return expanded, err
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		environ  []string
+		expected string
+	}{
+		{
+			name:     "numbers are parsed",
+			config:   "port: 8080",
+			environ:  []string{"ICON_METRICS_PORT=9090", "ICON_METRICS_SHUTDOWN_TIMEOUT=10"},
+			expected: "port: 9090\nshutdownTimeout: 10",
+		},
+		{
+			name:     "strings are not parsed",
+			config:   "devices:\n  - sysid: '123456789012'",
+			environ:  []string{"ICON_METRICS_DEVICES_0_PASSWORD=123"},
+			expected: "devices:\n  - sysid: '123456789012'\n    password: '123'",
+		},
+		{
+			name:     "camel case settings are split with underscores",
+			config:   "devices:\n  - sysid: '123456789012'",
+			environ:  []string{"ICON_METRICS_DEVICES_0_PASSWORD_FILE=/run/secrets/password", "ICON_METRICS_DEVICES_0_HOLD_FAILURES=0"},
+			expected: "devices:\n  - sysid: '123456789012'\n    passwordFile: /run/secrets/password\n    hold:\n      failures: 0",
+		},
+		{
+			name:     "missing list items are added",
+			config:   "devices:\n  - sysid: '123456789012'",
+			environ:  []string{"ICON_METRICS_DEVICES_1_SYSID=210987654321"},
+			expected: "devices:\n  - sysid: '123456789012'\n  - sysid: '210987654321'",
+		},
+		{
+			name:     "bools are parsed",
+			config:   "defaults: {}",
+			environ:  []string{"ICON_METRICS_DEFAULTS_REPORT_HEATING=false"},
+			expected: "defaults:\n  report:\n    heating: false",
+		},
+		{
+			name:     "existing map keys are matched case insensitively",
+			config:   "devices:\n  - labels:\n      Site: home",
+			environ:  []string{"ICON_METRICS_DEVICES_0_LABELS_SITE=office", "ICON_METRICS_DEVICES_0_LABELS_FLOOR_NAME=first"},
+			expected: "devices:\n  - labels:\n      Site: office\n      floor_name: first",
+		},
+		{
+			name:     "nested map keys are lower cased",
+			config:   "groups: {}",
+			environ:  []string{"ICON_METRICS_GROUPS_UPSTAIRS_DELAY=30", "ICON_METRICS_GROUPS_UPSTAIRS_ROOM_LABELS_1_FLOOR=1"},
+			expected: "groups:\n  upstairs:\n    delay: 30\n    roomLabels:\n      '1':\n        floor: '1'",
+		},
+		{
+			name:     "new map keys can contain underscores",
+			config:   "modules: {}",
+			environ:  []string{"ICON_METRICS_MODULES_FIRST_FLOOR_PASSWORD_FILE=/run/secrets/password"},
+			expected: "modules:\n  first_floor:\n    passwordFile: /run/secrets/password",
+		},
+		{
+			name:     "unknown and not prefixed variables are ignored",
+			config:   "port: 8080",
+			environ:  []string{"ICON_METRICS_FOO=bar", "ICON_METRICS_DEVICES_X_URL=http://device", "ICON_METRICS_PORT_NUMBER=80", "ICON_METRICS_GROUPS_UPSTAIRS_DELAYS=30", "PORT=9090", "ICON_PORT=tcp://10.0.0.1:80", "ICON_METRICS_EMPTY"},
+			expected: "port: 8080",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			document := &yaml.Node{}
+			err := yaml.Unmarshal([]byte(test.config), document)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = applyEnvironment(document, test.environ)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual, expected any
+			err = document.Decode(&actual)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = yaml.Unmarshal([]byte(test.expected), &expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestApplyEnvironmentInvalidValue(t *testing.T) {
+	document := &yaml.Node{}
+	err := yaml.Unmarshal([]byte("port: 8080"), document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = applyEnvironment(document, []string{"ICON_METRICS_PORT=[8080"})
+	if err == nil {
+		t.Error("expected error for invalid value")
+	}
+}
+
+func TestApplyEnvironmentIgnored(t *testing.T) {
+	document := &yaml.Node{}
+	err := yaml.Unmarshal([]byte("devices:\n  - url: ${ICON_METRICS_URL}"), document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ignored, err := applyEnvironment(document, []string{"ICON_METRICS_URL=http://device", "ICON_METRICS_FOO=bar", "ICON_FOO=bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ignored) != 1 || ignored[0] != "ICON_METRICS_FOO" {
+		t.Errorf("expected only ICON_METRICS_FOO to be ignored, got %v", ignored)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("ICON_TEST_PASSWORD", "secret")
+	expanded, err := expandEnv("${ICON_TEST_PASSWORD}:$${ICON_TEST_PASSWORD}:$$:${}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expanded != "secret:${ICON_TEST_PASSWORD}:$$:${}" {
+		t.Errorf("unexpected expansion %s", expanded)
+	}
+	_, err = expandEnv("${ICON_TEST_MISSING}")
+	if err == nil {
+		t.Error("missing variable is expanded")
+	}
+	_, err = expandEnv("$${ICON_TEST_MISSING}")
+	if err != nil {
+		t.Errorf("escaped missing variable is expanded: %v", err)
+	}
+}
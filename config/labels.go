@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Valid prometheus label name.
+var labelNamePattern = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// Label names used by the metrics.
+var reservedLabelNames = []string{"sysId", "id", "room", "name", "response", "error", "change", "stage", "result"}
+
+// Returns the sorted names of all device labels.
+func (config *Configuration) DeviceLabelNames() []string {
+	names := make([]string, 0)
+	for _, device := range config.allDevices() {
+		for name := range device.Labels {
+			names = append(names, name)
+		}
+	}
+	if config.Discovery != nil {
+		names = append(names, config.Discovery.LabelNames...)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Returns the sorted names of all room labels.
+func (config *Configuration) RoomLabelNames() []string {
+	names := make([]string, 0)
+	for _, device := range config.allDevices() {
+		for _, labels := range device.RoomLabels {
+			for name := range labels {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Returns the devices, the probe modules, the groups and the defaults.
+func (config *Configuration) allDevices() []*IconConfiguration {
+	devices := slices.Clone(config.Devices)
+	for _, module := range config.Modules {
+		devices = append(devices, module)
+	}
+	for _, group := range config.Groups {
+		devices = append(devices, group)
+	}
+	if config.Defaults != nil {
+		devices = append(devices, config.Defaults)
+	}
+	return devices
+}
+
+// Scans the labels for invalid names.
+func validateLabels(config *Configuration) error {
+	deviceNames := config.DeviceLabelNames()
+	for _, name := range deviceNames {
+		err := validateLabelName(name)
+		if err != nil {
+			return fmt.Errorf("invalid device label: %w", err)
+		}
+	}
+	for _, name := range config.RoomLabelNames() {
+		err := validateLabelName(name)
+		if err != nil {
+			return fmt.Errorf("invalid room label: %w", err)
+		}
+		if slices.Contains(deviceNames, name) {
+			return fmt.Errorf("invalid room label: %s is already a device label", name)
+		}
+	}
+	return nil
+}
+
+// Checks if the name can be used as a custom label name.
+func validateLabelName(name string) error {
+	if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("%s is not a valid label name", name)
+	}
+	if slices.Contains(reservedLabelNames, name) {
+		return fmt.Errorf("%s is a reserved label name", name)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Returns the settings of the devices, which are not set by the device, its group or the defaults.
+func builtinDevice() *IconConfiguration {
+	device := &IconConfiguration{
+		Delay:       integer(15),
+		Mode:        ModePoll,
+		MinInterval: integer(5),
+		Hold: &HoldConfiguration{
+			Failures:          integer(0),
+			Duration:          integer(0),
+			ConnectedDebounce: integer(0),
+		},
+		Report: &ReportConfiguration{},
+	}
+	// Every metric is reported by default.
+	setBools(reflect.ValueOf(device.Report).Elem(), true)
+	return device
+}
+
+// Merges the group and the defaults into the device, the settings of the device take precedence over the group and the defaults.
+func (config *Configuration) inherit(device *IconConfiguration, name string) error {
+	if device.Group != "" {
+		group, ok := config.Groups[device.Group]
+		if !ok {
+			return fmt.Errorf("%s has unknown group %s", name, device.Group)
+		}
+		inheritDevice(device, group)
+	}
+	if config.Defaults != nil {
+		inheritDevice(device, config.Defaults)
+	}
+	return nil
+}
+
+// Merges the template into the device, the password is not inherited if the device sets either the password or the passwordFile.
+func inheritDevice(device *IconConfiguration, template *IconConfiguration) {
+	source := *template
+	if device.Password != "" || device.PasswordFile != "" {
+		source.Password = ""
+		source.PasswordFile = ""
+	}
+	merge(device, &source)
+}
+
+// Checks that the defaults or the group does not set device or module specific settings.
+func validateTemplate(template *IconConfiguration, name string) error {
+	if template.Url != "" || template.SysId != "" || template.Group != "" || len(template.Targets) != 0 {
+		return fmt.Errorf("%s cannot set url, sysid, group or targets", name)
+	}
+	return nil
+}
+
+// Sets the unset fields of the destination from the source, the set fields are kept.
+// Zero scalars are unset, so the settings, which can be overridden with zero, are pointers.
+// Structs, pointers and maps are merged recursively, the slice items of the source are put before the destination items,
+// so the more specific items are applied last.
+func merge[T any](dst *T, src *T) {
+	mergeValue(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem())
+}
+
+// Sets the unset parts of the destination value from the source value.
+func mergeValue(dst reflect.Value, src reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			if dst.Type().Field(i).IsExported() {
+				mergeValue(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Pointer:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		} else if dst.Elem().Kind() != reflect.Struct {
+			// Set pointers are kept, so a disabled setting is not enabled by the source.
+			return
+		}
+		mergeValue(dst.Elem(), src.Elem())
+	case reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		merged := reflect.MakeMapWithSize(dst.Type(), dst.Len()+src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			value := copyValue(iter.Value())
+			if current := dst.MapIndex(iter.Key()); current.IsValid() {
+				value = copyValue(current)
+				mergeValue(value, iter.Value())
+			}
+			merged.SetMapIndex(iter.Key(), value)
+		}
+		iter = dst.MapRange()
+		for iter.Next() {
+			if !merged.MapIndex(iter.Key()).IsValid() {
+				merged.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		dst.Set(merged)
+	case reflect.Slice:
+		if src.Len() == 0 {
+			return
+		}
+		merged := reflect.MakeSlice(dst.Type(), 0, src.Len()+dst.Len())
+		for i := 0; i < src.Len(); i++ {
+			merged = reflect.Append(merged, copyValue(src.Index(i)))
+		}
+		dst.Set(reflect.AppendSlice(merged, dst))
+	default:
+		if dst.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
+
+// Returns a deep copy of the value.
+func copyValue(value reflect.Value) reflect.Value {
+	copied := reflect.New(value.Type()).Elem()
+	mergeValue(copied, value)
+	return copied
+}
+
+// Sets the unset bool pointers of the struct recursively.
+func setBools(value reflect.Value, b bool) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			setBools(field, b)
+		case field.Type() == reflect.TypeFor[*bool]() && field.IsNil():
+			value := b
+			field.Set(reflect.ValueOf(&value))
+		}
+	}
+}
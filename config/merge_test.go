@@ -0,0 +1,96 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInheritDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   *IconConfiguration
+		template *IconConfiguration
+		expected *IconConfiguration
+	}{
+		{
+			name:     "unset settings are inherited",
+			device:   &IconConfiguration{Url: "http://device"},
+			template: &IconConfiguration{Delay: integer(30), Mode: ModeScrape},
+			expected: &IconConfiguration{Url: "http://device", Delay: integer(30), Mode: ModeScrape},
+		},
+		{
+			name:     "set settings are kept",
+			device:   &IconConfiguration{Delay: integer(60), Mode: ModePoll},
+			template: &IconConfiguration{Delay: integer(30), Mode: ModeScrape},
+			expected: &IconConfiguration{Delay: integer(60), Mode: ModePoll},
+		},
+		{
+			name:     "zero hold settings are kept",
+			device:   &IconConfiguration{Hold: &HoldConfiguration{Failures: integer(0)}},
+			template: &IconConfiguration{Hold: &HoldConfiguration{Failures: integer(3), Duration: integer(60)}},
+			expected: &IconConfiguration{Hold: &HoldConfiguration{Failures: integer(0), Duration: integer(60)}},
+		},
+		{
+			name:     "disabled report settings are kept",
+			device:   &IconConfiguration{Report: &ReportConfiguration{Heating: disabled()}},
+			template: &IconConfiguration{Report: &ReportConfiguration{Heating: enabled(), HttpClient: disabled()}},
+			expected: &IconConfiguration{Report: &ReportConfiguration{Heating: disabled(), HttpClient: disabled()}},
+		},
+		{
+			name:     "labels are merged by name",
+			device:   &IconConfiguration{Labels: map[string]string{"site": "home", "floor": "1"}},
+			template: &IconConfiguration{Labels: map[string]string{"site": "office", "zone": "a"}},
+			expected: &IconConfiguration{Labels: map[string]string{"site": "home", "floor": "1", "zone": "a"}},
+		},
+		{
+			name:     "room labels are merged by room and name",
+			device:   &IconConfiguration{RoomLabels: map[string]map[string]string{"1": {"floor": "1"}}},
+			template: &IconConfiguration{RoomLabels: map[string]map[string]string{"1": {"floor": "0", "zone": "a"}, "2": {"zone": "b"}}},
+			expected: &IconConfiguration{RoomLabels: map[string]map[string]string{"1": {"floor": "1", "zone": "a"}, "2": {"zone": "b"}}},
+		},
+		{
+			name:     "template rooms are applied first",
+			device:   &IconConfiguration{Rooms: []*RoomConfiguration{{Id: "1"}}},
+			template: &IconConfiguration{Rooms: []*RoomConfiguration{{Id: "2", Exclude: true}}},
+			expected: &IconConfiguration{Rooms: []*RoomConfiguration{{Id: "2", Exclude: true}, {Id: "1"}}},
+		},
+		{
+			name:     "password is inherited",
+			device:   &IconConfiguration{},
+			template: &IconConfiguration{PasswordFile: "/run/secrets/password"},
+			expected: &IconConfiguration{PasswordFile: "/run/secrets/password"},
+		},
+		{
+			name:     "password is not inherited if the device sets the password file",
+			device:   &IconConfiguration{PasswordFile: "/run/secrets/device"},
+			template: &IconConfiguration{Password: "secret"},
+			expected: &IconConfiguration{PasswordFile: "/run/secrets/device"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inheritDevice(test.device, test.template)
+			if !reflect.DeepEqual(test.device, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, test.device)
+			}
+		})
+	}
+}
+
+func TestMergeCopiesSource(t *testing.T) {
+	template := &IconConfiguration{
+		Hold:   &HoldConfiguration{Failures: integer(3)},
+		Report: &ReportConfiguration{Heating: enabled()},
+		Rooms:  []*RoomConfiguration{{Id: "1"}},
+		Labels: map[string]string{"site": "home"},
+	}
+	device := &IconConfiguration{}
+	merge(device, template)
+	*device.Hold.Failures = 0
+	*device.Report.Heating = false
+	device.Rooms[0].Id = "2"
+	device.Labels["site"] = "office"
+	if *template.Hold.Failures != 3 || !*template.Report.Heating || template.Rooms[0].Id != "1" || template.Labels["site"] != "home" {
+		t.Errorf("source is changed by the destination: %+v", template)
+	}
+}
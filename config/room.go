@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// iCON room configuration override
+type RoomConfiguration struct {
+	// Room id to match.
+	Id string `yaml:"id"`
+	// Glob pattern to match the room name.
+	Name string `yaml:"name"`
+	// Regular expression to match the room name.
+	NameRegex string `yaml:"nameRegex"`
+	// Excludes the matching rooms from reporting.
+	Exclude bool `yaml:"exclude"`
+	// Overrides the device report configuration for the matching rooms.
+	Report *RoomReportConfiguration `yaml:"report"`
+	// Compiled NameRegex.
+	nameRegexp *regexp.Regexp
+}
+
+// iCON room report configuration
+type RoomReportConfiguration struct {
+	// metrics.RoomConntectedGauge
+	RoomConnected *bool `yaml:"roomConnected"`
+	// metrics.RoomTemperatureGauge
+	Temperature *bool `yaml:"temperature"`
+	// metrics.RoomDewTemperatureGauge
+	DewTemperature *bool `yaml:"dewTemperature"`
+	// metrics.RelayGauge
+	Relay *bool `yaml:"relay"`
+	// metrics.HumidityGauge
+	Humidity *bool `yaml:"humidity"`
+	// metrics.TargetTemperatureGauge
+	TargetTemperature *bool `yaml:"targetTemperature"`
+	// metrics.RoomInfoGauge
+	RoomInfo *bool `yaml:"roomInfo"`
+}
+
+// Returns if the room matches all the configured conditions.
+func (room *RoomConfiguration) Matches(id string, name string) bool {
+	if room.Id != "" && room.Id != id {
+		return false
+	}
+	if room.Name != "" {
+		matched, err := path.Match(room.Name, name)
+		if err != nil || !matched {
+			return false
+		}
+	}
+	if room.nameRegexp != nil && !room.nameRegexp.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// Scans the room config for invalid settings.
+func (room *RoomConfiguration) validate() error {
+	if room.Id == "" && room.Name == "" && room.NameRegex == "" {
+		return errors.New("one of id, name or nameRegex is required")
+	}
+	if room.Name != "" {
+		_, err := path.Match(room.Name, "")
+		if err != nil {
+			return fmt.Errorf("invalid name pattern %s: %w", room.Name, err)
+		}
+	}
+	if room.NameRegex != "" {
+		r, err := regexp.Compile(room.NameRegex)
+		if err != nil {
+			return fmt.Errorf("invalid nameRegex %s: %w", room.NameRegex, err)
+		}
+		room.nameRegexp = r
+	}
+	return nil
+}
+
+// Returns the effective report configuration of a room, or nil if the room is excluded.
+func (device *IconConfiguration) RoomReport(id string, name string) *RoomReportConfiguration {
+	report := device.Report.RoomReportConfiguration
+	for _, room := range device.Rooms {
+		if !room.Matches(id, name) {
+			continue
+		}
+		if room.Exclude {
+			return nil
+		}
+		if room.Report != nil {
+			report.override(room.Report)
+		}
+	}
+	return &report
+}
+
+// Overrides the set values.
+func (report *RoomReportConfiguration) override(other *RoomReportConfiguration) {
+	if other.RoomConnected != nil {
+		report.RoomConnected = other.RoomConnected
+	}
+	if other.Temperature != nil {
+		report.Temperature = other.Temperature
+	}
+	if other.DewTemperature != nil {
+		report.DewTemperature = other.DewTemperature
+	}
+	if other.Relay != nil {
+		report.Relay = other.Relay
+	}
+	if other.Humidity != nil {
+		report.Humidity = other.Humidity
+	}
+	if other.TargetTemperature != nil {
+		report.TargetTemperature = other.TargetTemperature
+	}
+	if other.RoomInfo != nil {
+		report.RoomInfo = other.RoomInfo
+	}
+}
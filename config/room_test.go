@@ -0,0 +1,82 @@
+package config
+
+import "testing"
+
+const roomsConfig = `
+devices:
+  - url: http://192.168.1.10
+    sysid: '123456789012'
+    report:
+      humidity: false
+    rooms:
+      - id: '1'
+        exclude: true
+      - name: 'Bath*'
+        report:
+          humidity: true
+      - nameRegex: '(?i)garage'
+        report:
+          temperature: false
+      - name: 'Bathroom 2'
+        report:
+          humidity: false
+`
+
+func TestRoomReport(t *testing.T) {
+	c, err := ParseConfig([]byte(roomsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := c.Devices[0]
+
+	if report := device.RoomReport("1", "Living room"); report != nil {
+		t.Errorf("room 1 is excluded by id, got %+v", report)
+	}
+	if report := device.RoomReport("2", "Living room"); report == nil || *report.Humidity || !*report.Temperature {
+		t.Errorf("unmatched room has the device report, got %+v", report)
+	}
+	if report := device.RoomReport("3", "Bathroom"); report == nil || !*report.Humidity {
+		t.Errorf("name pattern overrides the device report, got %+v", report)
+	}
+	if report := device.RoomReport("4", "Bathroom 2"); report == nil || *report.Humidity {
+		t.Errorf("later rooms override the earlier ones, got %+v", report)
+	}
+	if report := device.RoomReport("5", "Big GARAGE"); report == nil || *report.Temperature || *report.Humidity {
+		t.Errorf("name regex overrides only the set values, got %+v", report)
+	}
+	if report := device.RoomReport("1", "Bathroom"); report != nil {
+		t.Errorf("exclusion wins over the later overrides, got %+v", report)
+	}
+}
+
+func TestRoomMatches(t *testing.T) {
+	room := &RoomConfiguration{Id: "3", Name: "Bath*", NameRegex: "room$"}
+	err := room.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !room.Matches("3", "Bathroom") {
+		t.Error("room matching every condition is not matched")
+	}
+	if room.Matches("4", "Bathroom") {
+		t.Error("room with different id is matched")
+	}
+	if room.Matches("3", "Bath") {
+		t.Error("room not matching the regex is matched")
+	}
+	if room.Matches("3", "Guest bathroom") {
+		t.Error("room not matching the name pattern is matched")
+	}
+}
+
+func TestRoomValidate(t *testing.T) {
+	for _, room := range []*RoomConfiguration{
+		{Exclude: true},
+		{Name: "[room"},
+		{NameRegex: "(room"},
+	} {
+		if err := room.validate(); err == nil {
+			t.Errorf("expected error for %+v", room)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"gopkg.in/yaml.v3"
+)
+
+// JSON schema of the configuration file.
+//
+//go:embed config.schema.json
+var schemaData []byte
+
+// Compiled configuration schema.
+var compiledSchema = compileSchema()
+
+// Printer of the schema validation messages.
+var schemaPrinter = message.NewPrinter(language.English)
+
+// Configuration error at a position of the YAML document.
+type PositionError struct {
+	// Line of the setting, 0 if the setting is set from environment variable.
+	Line int
+	// Column of the setting, 0 if the setting is set from environment variable.
+	Column int
+	// Environment variable of the setting, empty if the setting is set from the file.
+	Env string
+	// Path of the setting, empty for the root.
+	Path string
+	Err  error
+}
+
+// Creates a new error at the position of the node.
+func newPositionError(node *yaml.Node, sources map[*yaml.Node]string, path string, err error) *PositionError {
+	return &PositionError{
+		Line:   node.Line,
+		Column: node.Column,
+		Env:    sources[node],
+		Path:   path,
+		Err:    err,
+	}
+}
+
+func (e *PositionError) Error() string {
+	position := fmt.Sprintf("line %d column %d", e.Line, e.Column)
+	if e.Env != "" {
+		position = fmt.Sprintf("environment variable %s", e.Env)
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", position, e.Err.Error())
+	}
+	return fmt.Sprintf("%s: %s: %s", position, e.Path, e.Err.Error())
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+// Compiles the embedded schema.
+func compileSchema() *jsonschema.Schema {
+	document, err := jsonschema.UnmarshalJSON(bytes.NewReader(schemaData))
+	if err != nil {
+		panic(fmt.Errorf("invalid embedded schema: %w", err))
+	}
+	compiler := jsonschema.NewCompiler()
+	err = compiler.AddResource("config.schema.json", document)
+	if err != nil {
+		panic(fmt.Errorf("invalid embedded schema: %w", err))
+	}
+	return compiler.MustCompile("config.schema.json")
+}
+
+// Validates the configuration document against the schema, returns every violation.
+func validateSchema(document *yaml.Node, sources map[*yaml.Node]string) []error {
+	value, err := nodeValue(document)
+	if err != nil {
+		return []error{err}
+	}
+	// The instance is converted to JSON, so numbers are typed as the schema expects.
+	data, err := json.Marshal(value)
+	if err != nil {
+		return []error{fmt.Errorf("failed to convert config: %w", err)}
+	}
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return []error{fmt.Errorf("failed to convert config: %w", err)}
+	}
+	err = compiledSchema.Validate(instance)
+	if err == nil {
+		return nil
+	}
+	validationError, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []error{err}
+	}
+	positionErrors := make([]*PositionError, 0)
+	for _, cause := range leafErrors(validationError) {
+		node := findNode(document, cause.InstanceLocation)
+		path := strings.Join(cause.InstanceLocation, ".")
+		switch errorKind := cause.ErrorKind.(type) {
+		case *kind.AdditionalProperties:
+			for _, property := range errorKind.Properties {
+				key := findKey(node, property)
+				positionErrors = append(positionErrors, newPositionError(key, sources, path, fmt.Errorf("unknown setting %s", property)))
+			}
+		case *kind.PropertyNames:
+			// The instance location of invalid property names is not reliable, the key is searched instead.
+			key, location := searchKey(document, errorKind.Property, nil)
+			if key == nil {
+				key, location = node, cause.InstanceLocation
+			}
+			path = strings.Join(location, ".")
+			reason := leafErrors(cause.Causes[0])[0].ErrorKind.LocalizedString(schemaPrinter)
+			positionErrors = append(positionErrors, newPositionError(key, sources, path, fmt.Errorf("invalid name %s: %s", errorKind.Property, reason)))
+		default:
+			positionErrors = append(positionErrors, newPositionError(node, sources, path, fmt.Errorf("%s", errorKind.LocalizedString(schemaPrinter))))
+		}
+	}
+	sort.SliceStable(positionErrors, func(i, j int) bool {
+		if positionErrors[i].Line != positionErrors[j].Line {
+			return positionErrors[i].Line < positionErrors[j].Line
+		}
+		return positionErrors[i].Column < positionErrors[j].Column
+	})
+	errs := make([]error, 0, len(positionErrors))
+	for _, positionError := range positionErrors {
+		errs = append(errs, positionError)
+	}
+	return errs
+}
+
+// Returns the validation errors without causes, invalid property names are not expanded.
+func leafErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if _, ok := err.ErrorKind.(*kind.PropertyNames); ok || len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+	leaves := make([]*jsonschema.ValidationError, 0)
+	for _, cause := range err.Causes {
+		leaves = append(leaves, leafErrors(cause)...)
+	}
+	return leaves
+}
+
+// Returns the node at the path, or the closest existing parent.
+func findNode(node *yaml.Node, path []string) *yaml.Node {
+	for node.Kind == yaml.DocumentNode || node.Kind == yaml.AliasNode {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		} else if len(node.Content) > 0 {
+			node = node.Content[0]
+		} else {
+			return node
+		}
+	}
+	if len(path) == 0 {
+		return node
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value == path[0] {
+				return findNode(node.Content[i+1], path[1:])
+			}
+		}
+	case yaml.SequenceNode:
+		i, err := strconv.Atoi(path[0])
+		if err == nil && i >= 0 && i < len(node.Content) {
+			return findNode(node.Content[i], path[1:])
+		}
+	}
+	return node
+}
+
+// Returns the key node of the mapping, or the mapping if the key is missing.
+func findKey(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i]
+			}
+		}
+	}
+	return node
+}
+
+// Returns the first mapping key node with the value and the path of its mapping, nil if not found.
+func searchKey(node *yaml.Node, key string, path []string) (*yaml.Node, []string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			found, location := searchKey(child, key, path)
+			if found != nil {
+				return found, location
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i], path
+			}
+			found, location := searchKey(node.Content[i+1], key, append(slices.Clone(path), node.Content[i].Value))
+			if found != nil {
+				return found, location
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			found, location := searchKey(child, key, append(slices.Clone(path), strconv.Itoa(i)))
+			if found != nil {
+				return found, location
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Converts the node to a JSON compatible value, mapping keys are always strings.
+func nodeValue(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return nodeValue(node.Content[0])
+	case yaml.AliasNode:
+		return nodeValue(node.Alias)
+	case yaml.MappingNode:
+		value := make(map[string]any)
+		for i := 0; i < len(node.Content); i += 2 {
+			v, err := nodeValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			value[node.Content[i].Value] = v
+		}
+		return value, nil
+	case yaml.SequenceNode:
+		value := make([]any, 0, len(node.Content))
+		for _, child := range node.Content {
+			v, err := nodeValue(child)
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, v)
+		}
+		return value, nil
+	}
+	var value any
+	err := node.Decode(&value)
+	if err != nil {
+		return nil, fmt.Errorf("line %d column %d: %w", node.Line, node.Column, err)
+	}
+	return value, nil
+}
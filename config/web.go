@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Client certificate policies of the TLS server.
+var tlsClientAuthTypes = []string{
+	"NoClientCert",
+	"RequestClientCert",
+	"RequireAnyClientCert",
+	"VerifyClientCertIfGiven",
+	"RequireAndVerifyClientCert",
+}
+
+// TLS versions of the TLS server.
+var tlsVersions = []string{"TLS10", "TLS11", "TLS12", "TLS13"}
+
+// Web configuration, compatible with the Prometheus exporter toolkit web configuration file.
+type WebConfiguration struct {
+	TlsServer *TlsServerConfiguration `yaml:"tls_server_config"`
+	// Password bcrypt hashes by user name.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLS server configuration
+type TlsServerConfiguration struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Client certificate policy, like RequireAndVerifyClientCert.
+	ClientAuthType string `yaml:"client_auth_type"`
+	// CA certificates to verify the client certificates with.
+	ClientCaFile string `yaml:"client_ca_file"`
+	// Minimum TLS version, like TLS12.
+	MinVersion string `yaml:"min_version"`
+	// Maximum TLS version, like TLS13.
+	MaxVersion string `yaml:"max_version"`
+}
+
+// Returns the network and the address of the http server.
+func (config *Configuration) ListenAddress() (string, string) {
+	return listenAddress(config.Address, config.Port)
+}
+
+// Returns if the admin http server is enabled.
+func (admin *AdminConfiguration) Enabled() bool {
+	return admin.Port != 0 || strings.HasPrefix(admin.Address, "unix:")
+}
+
+// Returns the network and the address of the admin http server.
+func (admin *AdminConfiguration) ListenAddress() (string, string) {
+	return listenAddress(admin.Address, admin.Port)
+}
+
+// Returns the network and the address of the host and port, or of the unix socket.
+func listenAddress(address string, port int) (string, string) {
+	path, ok := strings.CutPrefix(address, "unix:")
+	if ok {
+		return "unix", path
+	}
+	return "tcp", net.JoinHostPort(address, strconv.Itoa(port))
+}
+
+// Returns the web config that is read from the file.
+// Relative file paths are resolved from the directory of the web config file.
+func ReadWebConfig(filepath string) (*WebConfiguration, error) {
+	web := &WebConfiguration{}
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return web, fmt.Errorf("failed to read web config: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(web)
+	if err != nil {
+		return web, fmt.Errorf("failed to parse web config: %w", err)
+	}
+	err = validateWebConfig(web, filepath)
+	if err != nil {
+		return web, fmt.Errorf("invalid web config: %w", err)
+	}
+	return web, nil
+}
+
+// Scans the web config for invalid settings and resolves the file paths.
+func validateWebConfig(web *WebConfiguration, path string) error {
+	for user, hash := range web.BasicAuthUsers {
+		_, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return fmt.Errorf("basic auth user %s has invalid bcrypt hash: %w", user, err)
+		}
+	}
+	tls := web.TlsServer
+	if tls == nil {
+		return nil
+	}
+	if tls.CertFile == "" || tls.KeyFile == "" {
+		return fmt.Errorf("tls_server_config requires both cert_file and key_file")
+	}
+	if tls.ClientAuthType == "" {
+		tls.ClientAuthType = "NoClientCert"
+		if tls.ClientCaFile != "" {
+			tls.ClientAuthType = "RequireAndVerifyClientCert"
+		}
+	}
+	if !slices.Contains(tlsClientAuthTypes, tls.ClientAuthType) {
+		return fmt.Errorf("invalid client_auth_type %s", tls.ClientAuthType)
+	}
+	verified := tls.ClientAuthType == "VerifyClientCertIfGiven" || tls.ClientAuthType == "RequireAndVerifyClientCert"
+	if verified && tls.ClientCaFile == "" {
+		return fmt.Errorf("client_auth_type %s requires client_ca_file", tls.ClientAuthType)
+	}
+	if tls.MinVersion == "" {
+		tls.MinVersion = "TLS12"
+	}
+	if !slices.Contains(tlsVersions, tls.MinVersion) {
+		return fmt.Errorf("invalid min_version %s", tls.MinVersion)
+	}
+	if tls.MaxVersion == "" {
+		tls.MaxVersion = "TLS13"
+	}
+	if !slices.Contains(tlsVersions, tls.MaxVersion) {
+		return fmt.Errorf("invalid max_version %s", tls.MaxVersion)
+	}
+	dir := filepath.Dir(path)
+	tls.CertFile = resolvePath(dir, tls.CertFile)
+	tls.KeyFile = resolvePath(dir, tls.KeyFile)
+	tls.ClientCaFile = resolvePath(dir, tls.ClientCaFile)
+	return nil
+}
+
+// Returns the path relative to the directory, absolute and empty paths are not changed.
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/client"
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/metrics"
+	"github.com/csutorasa/icon-metrics/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Output formats of the dump command.
+const (
+	formatJson  = "json"
+	formatTable = "table"
+)
+
+// Registers the device selector flag.
+func deviceFlag(flags *flag.FlagSet) *string {
+	return flags.String("device", "", "System ID of the device, every configured device if empty")
+}
+
+// Reads the configuration file from the path flag.
+func readCommandConfig(configPath string) (*config.Configuration, error) {
+	c, err := config.ReadConfig(resolveConfigPath(configPath))
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Devices) == 0 {
+		return nil, errors.New("there are no devices configured")
+	}
+	return c, nil
+}
+
+// Returns the configured devices, or only the one with the sysid if it is set.
+func selectDevices(c *config.Configuration, sysId string) ([]*config.IconConfiguration, error) {
+	if sysId == "" {
+		return c.Devices, nil
+	}
+	for _, device := range c.Devices {
+		if device.SysId == sysId {
+			return []*config.IconConfiguration{device}, nil
+		}
+	}
+	return nil, fmt.Errorf("device %s is not configured", sysId)
+}
+
+// Returns the single selected device, the sysid is required if there are more devices.
+func selectDevice(c *config.Configuration, sysId string) (*config.IconConfiguration, error) {
+	devices, err := selectDevices(c, sysId)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) != 1 {
+		return nil, errors.New("device flag is required, there are more devices configured")
+	}
+	return devices[0], nil
+}
+
+// Creates a logged in client for the device, the metrics are not published.
+func connect(c *config.Configuration, device *config.IconConfiguration) (client.IconClient, error) {
+	reporter := metrics.NewPrometheusReporter(prometheus.NewRegistry(), c)
+	session := metrics.NewSession(device, reporter)
+	iconClient, err := client.NewIconClient(device.Url, device.SysId, device.Password, session)
+	if err != nil {
+		return nil, err
+	}
+	err = iconClient.Login()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", device.SysId, err)
+	}
+	return iconClient, nil
+}
+
+// Logs in to the devices and prints the latency, the firmware version and the room count.
+func probeCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	sysId := deviceFlag(flags)
+	return func() error {
+		c, err := readCommandConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		devices, err := selectDevices(c, *sysId)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SYSID\tURL\tLOGIN\tREAD\tVERSION\tROOMS\tERROR")
+		failed := 0
+		for _, device := range devices {
+			login, read, values, err := probeDevice(c, device)
+			if err != nil {
+				failed++
+			}
+			version, rooms := "-", "-"
+			if values != nil {
+				version = values.Version
+				rooms = strconv.Itoa(enabledRooms(values))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", device.SysId, device.Url, formatLatency(login), formatLatency(read), version, rooms, errorText(err))
+		}
+		w.Flush()
+		if failed != 0 {
+			return fmt.Errorf("%d of %d devices failed", failed, len(devices))
+		}
+		return nil
+	}
+}
+
+// Logs in, reads the values and logs out, returns the login and the read latency.
+func probeDevice(c *config.Configuration, device *config.IconConfiguration) (time.Duration, time.Duration, *model.DataPollResponse, error) {
+	timer := metrics.NewTimer()
+	iconClient, err := connect(c, device)
+	login := timer.End()
+	if err != nil {
+		return login, 0, nil, err
+	}
+	defer iconClient.Close()
+	timer = metrics.NewTimer()
+	values, err := iconClient.ReadValues()
+	read := timer.End()
+	if err != nil {
+		return login, read, nil, fmt.Errorf("failed to read values: %w", err)
+	}
+	return login, read, values, nil
+}
+
+// Returns the number of enabled rooms.
+func enabledRooms(values *model.DataPollResponse) int {
+	rooms := 0
+	for _, thermostat := range values.Thermostats {
+		if thermostat.Enabled != 0 {
+			rooms++
+		}
+	}
+	return rooms
+}
+
+// Returns the latency in milliseconds, - if the step was not run.
+func formatLatency(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fms", float64(d.Microseconds())/1000)
+}
+
+// Returns the error message, - if there was no error.
+func errorText(err error) string {
+	if err == nil {
+		return "-"
+	}
+	return err.Error()
+}
+
+// Prints the values read from the devices.
+func dumpCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	sysId := deviceFlag(flags)
+	format := flags.String("format", formatTable, "Output format, either table or json")
+	return func() error {
+		if *format != formatTable && *format != formatJson {
+			return fmt.Errorf("invalid format %s", *format)
+		}
+		c, err := readCommandConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		devices, err := selectDevices(c, *sysId)
+		if err != nil {
+			return err
+		}
+		responses := make(map[string]*model.DataPollResponse)
+		for _, device := range devices {
+			values, err := readDevice(c, device)
+			if err != nil {
+				return err
+			}
+			responses[device.SysId] = values
+		}
+		if *format == formatJson {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(responses)
+		}
+		for i, device := range devices {
+			if i > 0 {
+				fmt.Println()
+			}
+			printValues(os.Stdout, device, responses[device.SysId])
+		}
+		return nil
+	}
+}
+
+// Logs in, reads the values and logs out.
+func readDevice(c *config.Configuration, device *config.IconConfiguration) (*model.DataPollResponse, error) {
+	iconClient, err := connect(c, device)
+	if err != nil {
+		return nil, err
+	}
+	defer iconClient.Close()
+	values, err := iconClient.ReadValues()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values of %s: %w", device.SysId, err)
+	}
+	return values, nil
+}
+
+// Prints the device and the room values as tables.
+func printValues(out io.Writer, device *config.IconConfiguration, values *model.DataPollResponse) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Device\t%s\n", device.SysId)
+	fmt.Fprintf(w, "Url\t%s\n", device.Url)
+	fmt.Fprintf(w, "Version\t%s\n", values.Version)
+	fmt.Fprintf(w, "Mode\t%s\n", heatingCoolingText(values.HeatingCooling, values.ComfortEco))
+	fmt.Fprintf(w, "External temperature\t%.1f\n", values.ExternalTemperature)
+	fmt.Fprintf(w, "Water temperature\t%.1f\n", values.WaterTemperature)
+	fmt.Fprintf(w, "Target temperature\t%.1f\n", values.TargetTemperature())
+	w.Flush()
+	ids := make([]string, 0, len(values.Thermostats))
+	for id := range values.Thermostats {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return roomIdLess(ids[i], ids[j])
+	})
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOM\tNAME\tON\tLIVE\tMODE\tTEMP\tTARGET\tRH\tDEW\tRELAY")
+	for _, id := range ids {
+		thermostat := values.Thermostats[id]
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%.1f\t%.1f\t%.1f\t%.1f\t%d\n", id, thermostat.Name, thermostat.Enabled, thermostat.Live,
+			heatingCoolingText(thermostat.HeatingCooling, thermostat.ComfortEco), thermostat.Temperature, thermostat.TargetTemperature(),
+			thermostat.RelativeHumidity, thermostat.DewTemperature, thermostat.Relay)
+	}
+	w.Flush()
+}
+
+// Returns the heating or cooling and the comfort or eco mode as text.
+func heatingCoolingText(hc model.HC, ce model.CE) string {
+	text := "heating"
+	if hc == model.Cooling {
+		text = "cooling"
+	}
+	if ce == model.Eco {
+		return text + " eco"
+	}
+	return text + " comfort"
+}
+
+// Orders the numeric room ids numerically, others alphabetically.
+func roomIdLess(a string, b string) bool {
+	i, errA := strconv.Atoi(a)
+	j, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return i < j
+	}
+	return a < b
+}
+
+// Returns if the flag is set on the command line.
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Sets the thermostat settings of a room, the settings not set by flags are kept.
+func setThermostatCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	sysId := deviceFlag(flags)
+	tab := flags.Int("tab", 0, "Controller tab of the room")
+	room := flags.String("room", "", "Room id")
+	name := flags.String("name", "", "Room name")
+	installed := flags.Bool("installed", true, "If the thermostat is installed")
+	heating := flags.Float64("heating", 0, "Comfort heating target temperature")
+	cooling := flags.Float64("cooling", 0, "Comfort cooling target temperature")
+	ecoHeating := flags.Float64("eco-heating", 0, "Eco heating target temperature")
+	ecoCooling := flags.Float64("eco-cooling", 0, "Eco cooling target temperature")
+	return func() error {
+		signal, err := strconv.Atoi(*room)
+		if err != nil {
+			return fmt.Errorf("invalid room id %q", *room)
+		}
+		c, err := readCommandConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		device, err := selectDevice(c, *sysId)
+		if err != nil {
+			return err
+		}
+		iconClient, err := connect(c, device)
+		if err != nil {
+			return err
+		}
+		defer iconClient.Close()
+		values, err := iconClient.ReadValues()
+		if err != nil {
+			return fmt.Errorf("failed to read values: %w", err)
+		}
+		thermostat, ok := values.Thermostats[*room]
+		if !ok {
+			return fmt.Errorf("room %s is not found", *room)
+		}
+		setting := thermostatSetting(thermostat)
+		if isFlagSet(flags, "name") {
+			setting.Name = *name
+		}
+		if isFlagSet(flags, "installed") {
+			setting.Installed = *installed
+		}
+		if isFlagSet(flags, "heating") {
+			setting.HeatingTargetTemperature = *heating
+		}
+		if isFlagSet(flags, "cooling") {
+			setting.CoolingTargetTemperature = *cooling
+		}
+		if isFlagSet(flags, "eco-heating") {
+			setting.EcoHeatingTargetTemperature = *ecoHeating
+		}
+		if isFlagSet(flags, "eco-cooling") {
+			setting.EcoCoolingTargetTemperature = *ecoCooling
+		}
+		err = iconClient.SetThermostatSettings(*tab, model.ThermostatSettings{signal: setting})
+		if err != nil {
+			return fmt.Errorf("failed to set thermostat settings: %w", err)
+		}
+		fmt.Printf("Thermostat settings of room %s are set on %s\n", *room, device.SysId)
+		return nil
+	}
+}
+
+// Returns the current settings of the thermostat.
+func thermostatSetting(thermostat *model.DP) *model.ThermostatSetting {
+	return &model.ThermostatSetting{
+		HeatingCooling:              thermostat.HeatingCooling == model.Cooling,
+		Installed:                   thermostat.Enabled != 0,
+		EcoCoolingTargetTemperature: thermostat.EcoCoolingTargetTemperature,
+		EcoHeatingTargetTemperature: thermostat.EcoHeatingTargetTemperature,
+		CoolingTargetTemperature:    thermostat.CoolingTargetTemperature,
+		HeatingTargetTemperature:    thermostat.HeatingTargetTemperature,
+		Cef:                         thermostat.CEF != 0,
+		Cec:                         thermostat.CEC != 0,
+		Name:                        thermostat.Name,
+		ManualRange:                 thermostat.ManualRange,
+		RegBHeating:                 float64(thermostat.RegBHeating),
+		RegBCooling:                 float64(thermostat.RegBCooling),
+	}
+}
+
+// Input flags of the general settings, which are required, because the device does not report the current inputs.
+var generalInputFlags = []string{
+	"comfort-eco-mode", "comfort-eco-tab", "comfort-eco-signal",
+	"heating-cooling-mode", "heating-cooling-tab", "heating-cooling-signal",
+}
+
+// Sets the general settings of a device, the target temperatures not set by flags are kept, the inputs are required.
+func setGeneralCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	sysId := deviceFlag(flags)
+	tab := flags.Int("tab", 0, "Controller tab")
+	heating := flags.Int("heating", 0, "Comfort heating target temperature")
+	cooling := flags.Int("cooling", 0, "Comfort cooling target temperature")
+	ecoHeating := flags.Int("eco-heating", 0, "Eco heating target temperature")
+	ecoCooling := flags.Int("eco-cooling", 0, "Eco cooling target temperature")
+	comfortEcoMode := flags.String("comfort-eco-mode", "", "Function of the comfort or eco input, required")
+	comfortEcoTab := flags.Int("comfort-eco-tab", 0, "Controller tab of the comfort or eco input, required")
+	comfortEcoSignal := flags.Int("comfort-eco-signal", 0, "Signal of the comfort or eco input, required")
+	heatingCoolingMode := flags.String("heating-cooling-mode", "", "Function of the heating or cooling input, required")
+	heatingCoolingTab := flags.Int("heating-cooling-tab", 0, "Controller tab of the heating or cooling input, required")
+	heatingCoolingSignal := flags.Int("heating-cooling-signal", 0, "Signal of the heating or cooling input, required")
+	return func() error {
+		for _, name := range generalInputFlags {
+			if !isFlagSet(flags, name) {
+				return fmt.Errorf("--%s is required, the current inputs cannot be read from the device", name)
+			}
+		}
+		c, err := readCommandConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		device, err := selectDevice(c, *sysId)
+		if err != nil {
+			return err
+		}
+		iconClient, err := connect(c, device)
+		if err != nil {
+			return err
+		}
+		defer iconClient.Close()
+		values, err := iconClient.ReadValues()
+		if err != nil {
+			return fmt.Errorf("failed to read values: %w", err)
+		}
+		settings := &model.GeneralSettings{
+			ComfortEcoMode:       *comfortEcoMode,
+			ComfortEcoTab:        *comfortEcoTab,
+			ComfortEcoSignal:     *comfortEcoSignal,
+			HeatingCoolingMode:   *heatingCoolingMode,
+			HeatingCoolingTab:    *heatingCoolingTab,
+			HeatingCoolingSignal: *heatingCoolingSignal,
+		}
+		targets := []struct {
+			flag    string
+			value   *int
+			current float64
+			target  *int
+		}{
+			{"heating", heating, values.HeatingTargetTemperature, &settings.HeatingTargetTemperature},
+			{"cooling", cooling, values.CoolingTargetTemperature, &settings.CoolingTargetTemperature},
+			{"eco-heating", ecoHeating, values.EcoHeatingTargetTemperature, &settings.EcoHeatingTargetTemperature},
+			{"eco-cooling", ecoCooling, values.EcoCoolingTargetTemperature, &settings.EcoCoolingTargetTemperature},
+		}
+		for _, target := range targets {
+			if isFlagSet(flags, target.flag) {
+				*target.target = *target.value
+				continue
+			}
+			// The general settings accept whole degrees only, so the current value is not rounded silently.
+			if target.current != math.Trunc(target.current) {
+				return fmt.Errorf("current %s target temperature %.1f is not a whole degree, set it with --%s", target.flag, target.current, target.flag)
+			}
+			*target.target = int(target.current)
+		}
+		err = iconClient.SetGeneralSettings(*tab, settings)
+		if err != nil {
+			return fmt.Errorf("failed to set general settings: %w", err)
+		}
+		fmt.Printf("General settings are set on %s\n", device.SysId)
+		return nil
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/client"
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/logging"
+	"github.com/csutorasa/icon-metrics/metrics"
+)
+
+// Duration a single read can take before the device is reported as stalled by the liveness check.
+const stallTimeout = time.Minute
+
+// Runs the monitored iCON devices and applies the configuration changes.
+type deviceManager struct {
+	reporter metrics.MetricsReporter
+	trigger  metrics.ScrapeTrigger
+	// Serialises the configuration changes, held while the removed devices are stopped.
+	applyLock sync.Mutex
+	lock      sync.Mutex
+	// Running devices by sysid.
+	runners map[string]*deviceRunner
+	// Set after the devices are stopped, no more devices are started.
+	closed bool
+}
+
+// Handles a single running iCON device.
+type deviceRunner struct {
+	configuration *config.IconConfiguration
+	client        client.IconClient
+	session       metrics.MetricsSession
+	// Closed to stop the device.
+	stop chan struct{}
+	// Holds the pending configuration change.
+	updates chan *config.IconConfiguration
+	// Closed after the device is stopped and disconnected.
+	done chan struct{}
+	// Progress of the reads for the liveness check.
+	progress *readProgress
+}
+
+// Progress of the reads of a device, safe to use from any goroutine.
+type readProgress struct {
+	lock sync.Mutex
+	// Expected duration between the reads, 0 if the device is read on scrape.
+	interval time.Duration
+	// Start of the read in progress, zero if the device is not being read.
+	started time.Time
+	// End of the last read, or the start of the device.
+	finished time.Time
+}
+
+// Creates a new manager without running devices.
+func newDeviceManager(reporter metrics.MetricsReporter, trigger metrics.ScrapeTrigger) *deviceManager {
+	return &deviceManager{
+		reporter: reporter,
+		trigger:  trigger,
+		runners:  make(map[string]*deviceRunner),
+	}
+}
+
+// Starts the new devices, stops the removed ones and updates the changed ones.
+// The removed devices are stopped without the lock until the timeout, so the health checks are answered during the reload.
+func (manager *deviceManager) apply(devices []*config.IconConfiguration, timeout time.Duration) {
+	manager.applyLock.Lock()
+	defer manager.applyLock.Unlock()
+	manager.lock.Lock()
+	if manager.closed {
+		manager.lock.Unlock()
+		return
+	}
+	configured := make(map[string]*config.IconConfiguration)
+	for _, device := range devices {
+		configured[device.SysId] = device
+	}
+	stopped := make([]*deviceRunner, 0)
+	for sysId, runner := range manager.runners {
+		device, ok := configured[sysId]
+		if !ok {
+			slog.Info("Device is removed from the configuration", logging.Operation("reload"), slog.String("sysId", sysId))
+		} else if requiresRestart(runner.configuration, device) {
+			slog.Info("Device connection configuration is changed, restarting", logging.Operation("reload"), slog.String("sysId", sysId))
+		} else {
+			continue
+		}
+		delete(manager.runners, sysId)
+		stopped = append(stopped, runner)
+	}
+	manager.lock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := stopRunners(ctx, stopped)
+	if err != nil {
+		// The restarted devices are started anyway, their metrics are reported again on the next read.
+		slog.Warn("Failed to stop every removed device before the deadline", logging.Operation("reload"), logging.Error(err))
+	}
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.closed {
+		return
+	}
+	for _, device := range devices {
+		runner, ok := manager.runners[device.SysId]
+		if !ok {
+			manager.start(device)
+		} else if !reflect.DeepEqual(runner.configuration, device) {
+			slog.Info("Device configuration is changed, updating", logging.Operation("reload"), slog.String("sysId", device.SysId))
+			runner.update(device)
+		}
+	}
+}
+
+// Stops all devices and waits until they are disconnected or the context is done.
+// The lock is not held while waiting, so the health checks are answered during the shutdown.
+func (manager *deviceManager) close(ctx context.Context) error {
+	manager.lock.Lock()
+	manager.closed = true
+	stopped := make([]*deviceRunner, 0, len(manager.runners))
+	for sysId, runner := range manager.runners {
+		delete(manager.runners, sysId)
+		stopped = append(stopped, runner)
+	}
+	manager.lock.Unlock()
+	return stopRunners(ctx, stopped)
+}
+
+// Returns the health state of the running devices by sysid and if the devices are stopped.
+func (manager *deviceManager) states() (map[string]metrics.SessionState, bool) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	states := make(map[string]metrics.SessionState, len(manager.runners))
+	for sysId, runner := range manager.runners {
+		states[sysId] = runner.session.State()
+	}
+	return states, manager.closed
+}
+
+// Returns a copy of the running devices sorted by sysid.
+func (manager *deviceManager) running() []deviceRunner {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	running := make([]deviceRunner, 0, len(manager.runners))
+	for _, runner := range manager.runners {
+		running = append(running, *runner)
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].configuration.SysId < running[j].configuration.SysId
+	})
+	return running
+}
+
+// Returns the sysids of the devices, whose reads are stuck or are not started in time.
+func (manager *deviceManager) stalled() []string {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	stalled := make([]string, 0)
+	for sysId, runner := range manager.runners {
+		if runner.progress.stalled() {
+			stalled = append(stalled, sysId)
+		}
+	}
+	sort.Strings(stalled)
+	return stalled
+}
+
+// Creates a client for the device and starts reading it.
+func (manager *deviceManager) start(device *config.IconConfiguration) {
+	session := metrics.NewSession(device, manager.reporter)
+	c, err := client.NewIconClient(device.Url, device.SysId, device.Password, session)
+	if err != nil {
+		logging.Device(device.SysId, device.Url).Error("Failed to create client", logging.Operation("connect"), logging.Error(err))
+		return
+	}
+	runner := &deviceRunner{
+		configuration: device,
+		client:        c,
+		session:       session,
+		stop:          make(chan struct{}),
+		updates:       make(chan *config.IconConfiguration, 1),
+		done:          make(chan struct{}),
+		progress:      newReadProgress(device),
+	}
+	manager.runners[device.SysId] = runner
+	go func() {
+		defer close(runner.done)
+		defer func() {
+			start := metrics.NewTimer()
+			logger := c.Logger()
+			logger.Info("Disconnecting", logging.Operation("logout"))
+			err := c.Close()
+			session.Close()
+			if err != nil {
+				logger.Warn("Failed to disconnect", logging.Operation("logout"), logging.Error(err))
+			} else {
+				logger.Info("Successfully disconnected", logging.Operation("logout"), slog.Duration("duration", start.End()))
+			}
+		}()
+		if device.Mode == config.ModeScrape {
+			minInterval := time.Duration(*device.MinInterval) * time.Second
+			refresher := newScrapeRefresher(c, minInterval, session, runner.progress)
+			manager.trigger.Register(c.SysId(), refresher.refresh)
+			defer manager.trigger.Unregister(c.SysId())
+			refresher.wait(runner.stop, runner.updates)
+		} else {
+			delay := time.Duration(*device.Delay) * time.Second
+			reportValues(c, runner.stop, runner.updates, delay, session, runner.progress)
+		}
+	}()
+}
+
+// Replaces the pending configuration change of the device.
+func (runner *deviceRunner) update(device *config.IconConfiguration) {
+	runner.configuration = device
+	select {
+	case <-runner.updates:
+	default:
+	}
+	runner.updates <- device
+}
+
+// Creates a new progress of the device, which is not being read.
+func newReadProgress(device *config.IconConfiguration) *readProgress {
+	progress := &readProgress{finished: time.Now()}
+	progress.configure(device)
+	return progress
+}
+
+// Sets the expected duration between the reads.
+func (progress *readProgress) configure(device *config.IconConfiguration) {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.interval = 0
+	if device.Mode == config.ModePoll {
+		progress.interval = time.Duration(*device.Delay) * time.Second
+	}
+}
+
+// Reports the start of a read.
+func (progress *readProgress) start() {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.started = time.Now()
+}
+
+// Reports the end of a read.
+func (progress *readProgress) finish() {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.started = time.Time{}
+	progress.finished = time.Now()
+}
+
+// Returns if the read in progress is stuck, or the next read is not started in time.
+func (progress *readProgress) stalled() bool {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	if !progress.started.IsZero() {
+		return time.Since(progress.started) > stallTimeout
+	}
+	return progress.interval > 0 && time.Since(progress.finished) > progress.interval+stallTimeout
+}
+
+// Stops the devices in parallel and waits until they are disconnected or the context is done.
+func stopRunners(ctx context.Context, runners []*deviceRunner) error {
+	for _, runner := range runners {
+		close(runner.stop)
+	}
+	for _, runner := range runners {
+		select {
+		case <-runner.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Returns if the device has to be reconnected to apply the configuration change.
+func requiresRestart(previous *config.IconConfiguration, current *config.IconConfiguration) bool {
+	return previous.Url != current.Url ||
+		previous.Password != current.Password ||
+		previous.Mode != current.Mode ||
+		!reflect.DeepEqual(previous.Labels, current.Labels) ||
+		!reflect.DeepEqual(previous.RoomLabels, current.RoomLabels)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/metrics"
+)
+
+// Returns if the device is being read.
+func (progress *readProgress) reading() bool {
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	return !progress.started.IsZero()
+}
+
+func TestApplyDoesNotBlockWhileStopping(t *testing.T) {
+	release := make(chan struct{})
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer device.Close()
+	defer close(release)
+
+	c := parseTestConfig(t, `
+devices:
+  - url: `+device.URL+`
+    sysid: '123456789012'
+    delay: 1
+`)
+	registry := metrics.NewRegistry(c.Collectors)
+	manager := newDeviceManager(metrics.NewPrometheusReporter(registry, c), metrics.NewScrapeTrigger(registry))
+	manager.apply(c.Devices, time.Second)
+	runner := manager.running()[0]
+	deadline := time.Now().Add(5 * time.Second)
+	for !runner.progress.reading() {
+		if time.Now().After(deadline) {
+			t.Fatal("device is not read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	applied := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(applied)
+		manager.apply(nil, 200*time.Millisecond)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	states, closed := manager.states()
+	if len(states) != 0 || closed {
+		t.Errorf("removed device is still running: %v", states)
+	}
+	select {
+	case <-applied:
+		t.Error("apply returned before the stop timeout, the device read is not in progress")
+	default:
+	}
+	<-applied
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("apply is not bounded by the timeout, took %v", elapsed)
+	}
+	select {
+	case <-runner.done:
+		t.Error("device is stopped while its read is in progress")
+	default:
+	}
+}
+
+func TestApplyUpdatesAndRestartsDevices(t *testing.T) {
+	device := httptest.NewServer(http.NotFoundHandler())
+	defer device.Close()
+
+	c := parseTestConfig(t, `
+devices:
+  - url: `+device.URL+`
+    sysid: '123456789012'
+    mode: scrape
+  - url: `+device.URL+`
+    sysid: '210987654321'
+    mode: scrape
+`)
+	registry := metrics.NewRegistry(c.Collectors)
+	manager := newDeviceManager(metrics.NewPrometheusReporter(registry, c), metrics.NewScrapeTrigger(registry))
+	manager.apply(c.Devices, time.Second)
+	before := manager.running()
+
+	changed := parseTestConfig(t, `
+devices:
+  - url: `+device.URL+`
+    sysid: '123456789012'
+    mode: scrape
+    minInterval: 10
+  - url: `+device.URL+`/other
+    sysid: '210987654321'
+    mode: scrape
+`)
+	manager.apply(changed.Devices, time.Second)
+	after := manager.running()
+	if len(after) != 2 {
+		t.Fatalf("expected 2 running devices, got %d", len(after))
+	}
+	if after[0].done != before[0].done || *after[0].configuration.MinInterval != 10 {
+		t.Error("changed device is not updated in place")
+	}
+	if after[1].done == before[1].done {
+		t.Error("device with changed url is not restarted")
+	}
+	select {
+	case <-before[1].done:
+	default:
+		t.Error("restarted device is not stopped")
+	}
+
+	err := manager.close(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.apply(c.Devices, time.Second)
+	if running := manager.running(); len(running) != 0 {
+		t.Errorf("devices are started after close: %d", len(running))
+	}
+}
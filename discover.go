@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/client"
+)
+
+// Maximum number of host bits of the scanned subnet.
+const discoverMaxHostBits = 16
+
+// Scans the subnet for iCON devices and prints the found ones.
+func discoverCommand(flags *flag.FlagSet) func() error {
+	subnet := flags.String("subnet", "", "Subnet to scan in CIDR notation, like 192.168.1.0/24")
+	port := flags.Int("port", 80, "Http port of the devices")
+	timeout := flags.Duration("timeout", 2*time.Second, "Timeout of a single host")
+	concurrency := flags.Int("concurrency", 64, "Maximum number of hosts scanned at the same time")
+	asYaml := flags.Bool("yaml", false, "Prints the found devices as devices configuration")
+	return func() error {
+		if *subnet == "" {
+			return errors.New("subnet flag is required")
+		}
+		if *concurrency < 1 {
+			return errors.New("concurrency must be at least 1")
+		}
+		hosts, err := subnetHosts(*subnet)
+		if err != nil {
+			return err
+		}
+		urls := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			urls = append(urls, hostUrl(host, *port))
+		}
+		devices := discoverDevices(urls, *timeout, *concurrency)
+		fmt.Fprintf(os.Stderr, "Scanned %d hosts, found %d devices\n", len(urls), len(devices))
+		if *asYaml {
+			printDevicesYaml(os.Stdout, devices)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "URL\tSYSID\tVERSION")
+		for _, device := range devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", device.Url, valueText(device.SysId), valueText(device.Version))
+		}
+		w.Flush()
+		return nil
+	}
+}
+
+// Returns the addresses of the subnet, the network and the broadcast addresses are skipped in IPv4 subnets.
+// A single address is accepted as a subnet too.
+func subnetHosts(subnet string) ([]netip.Addr, error) {
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(subnet)
+		if addrErr != nil {
+			return nil, fmt.Errorf("invalid subnet %s: %w", subnet, err)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > discoverMaxHostBits {
+		return nil, fmt.Errorf("subnet %s is too large, at most %d addresses can be scanned", subnet, 1<<discoverMaxHostBits)
+	}
+	hosts := make([]netip.Addr, 0, 1<<hostBits)
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		hosts = append(hosts, addr)
+	}
+	if prefix.Addr().Is4() && hostBits >= 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// Returns the url of the host, the port is omitted if it is the default one.
+func hostUrl(host netip.Addr, port int) string {
+	if port == 80 {
+		if host.Is6() {
+			return "http://[" + host.String() + "]"
+		}
+		return "http://" + host.String()
+	}
+	return "http://" + net.JoinHostPort(host.String(), strconv.Itoa(port))
+}
+
+// Identifies the devices at the urls in parallel, the found devices are returned in the order of the urls.
+func discoverDevices(urls []string, timeout time.Duration, concurrency int) []*client.DiscoveredDevice {
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+		},
+	}
+	results := make([]*client.DiscoveredDevice, len(urls))
+	semaphore := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	for i, u := range urls {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			// Hosts, which do not answer, are not devices.
+			results[i], _ = client.Identify(ctx, httpClient, u)
+		}()
+	}
+	wg.Wait()
+	devices := make([]*client.DiscoveredDevice, 0)
+	for _, device := range results {
+		if device != nil {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// Prints the devices as devices configuration, the sysid has to be filled in if the datapoll is not reachable.
+func printDevicesYaml(out io.Writer, devices []*client.DiscoveredDevice) {
+	if len(devices) == 0 {
+		fmt.Fprintln(out, "devices: []")
+		return
+	}
+	fmt.Fprintln(out, "devices:")
+	for _, device := range devices {
+		fmt.Fprintf(out, "  - url: %s # device address\n", yamlQuote(device.Url))
+		if device.SysId == "" {
+			fmt.Fprintf(out, "    sysid: '' # device ID (printed on the controller), the datapoll is not reachable without login\n")
+		} else {
+			fmt.Fprintf(out, "    sysid: %s # device ID, firmware version %s\n", yamlQuote(device.SysId), yamlComment(device.Version))
+		}
+	}
+}
+
+// Returns the value, - if it is empty.
+func valueText(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
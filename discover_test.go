@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubnetHosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		subnet string
+		count  int
+		first  string
+		last   string
+	}{
+		{name: "IPv4 subnet without network and broadcast", subnet: "192.168.1.0/24", count: 254, first: "192.168.1.1", last: "192.168.1.254"},
+		{name: "host bits are masked", subnet: "192.168.1.77/30", count: 2, first: "192.168.1.77", last: "192.168.1.78"},
+		{name: "point to point IPv4 subnet", subnet: "10.0.0.0/31", count: 2, first: "10.0.0.0", last: "10.0.0.1"},
+		{name: "single IPv4 address", subnet: "10.0.0.5", count: 1, first: "10.0.0.5", last: "10.0.0.5"},
+		{name: "single IPv4 address prefix", subnet: "10.0.0.5/32", count: 1, first: "10.0.0.5", last: "10.0.0.5"},
+		{name: "largest IPv4 subnet", subnet: "10.0.0.0/16", count: 65534, first: "10.0.0.1", last: "10.0.255.254"},
+		{name: "IPv6 subnet keeps every address", subnet: "fd00::/126", count: 4, first: "fd00::", last: "fd00::3"},
+		{name: "single IPv6 address", subnet: "fd00::1", count: 1, first: "fd00::1", last: "fd00::1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := subnetHosts(test.subnet)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(hosts) != test.count {
+				t.Fatalf("expected %d hosts, got %d", test.count, len(hosts))
+			}
+			if hosts[0] != netip.MustParseAddr(test.first) || hosts[len(hosts)-1] != netip.MustParseAddr(test.last) {
+				t.Errorf("expected hosts from %s to %s, got from %s to %s", test.first, test.last, hosts[0], hosts[len(hosts)-1])
+			}
+		})
+	}
+}
+
+func TestSubnetHostsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		subnet string
+	}{
+		{name: "invalid address", subnet: "192.168.1"},
+		{name: "invalid prefix", subnet: "192.168.1.0/33"},
+		{name: "hostname", subnet: "icon.local"},
+		{name: "too large IPv4 subnet", subnet: "10.0.0.0/15"},
+		{name: "too large IPv6 subnet", subnet: "fd00::/64"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := subnetHosts(test.subnet)
+			if err == nil {
+				t.Errorf("expected error for %s", test.subnet)
+			}
+		})
+	}
+}
+
+// Starts the handler on the loopback address and port, the test is skipped if the address cannot be used.
+func loopbackServer(t *testing.T, address string, port int, handler http.Handler) {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		t.Skipf("loopback address %s cannot be used: %v", address, err)
+	}
+	server := &httptest.Server{Listener: listener, Config: &http.Server{Handler: handler}}
+	server.Start()
+	t.Cleanup(server.Close)
+}
+
+func TestDiscoverDevices(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	release := make(chan struct{})
+	defer close(release)
+
+	loopbackServer(t, "127.0.0.1", port, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"SYSID":"123456789012","VER":"1.2.3"}`))
+		}
+	}))
+	loopbackServer(t, "127.0.0.2", port, http.NotFoundHandler())
+	loopbackServer(t, "127.0.0.3", port, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	loopbackServer(t, "127.0.0.4", port, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`<form><input name="sysid"><input name="password" type="password"></form>`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+
+	hosts, err := subnetHosts("127.0.0.0/29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		urls = append(urls, hostUrl(host, port))
+	}
+	start := time.Now()
+	devices := discoverDevices(urls, 500*time.Millisecond, 2)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("host, which does not answer, is not bounded by the timeout, took %v", elapsed)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+	if devices[0].Url != hostUrl(netip.MustParseAddr("127.0.0.1"), port) || devices[0].SysId != "123456789012" {
+		t.Errorf("device with datapoll is not found first, got %+v", devices[0])
+	}
+	if devices[1].Url != hostUrl(netip.MustParseAddr("127.0.0.4"), port) || devices[1].SysId != "" {
+		t.Errorf("device with login page is not found second, got %+v", devices[1])
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/logging"
+)
+
+// Maximum size of the device entries read from the url.
+const discoveryBodyLimit = 10 << 20
+
+// Discovers the devices from files and url, and runs them together with the configured devices.
+type deviceDiscovery struct {
+	manager    *deviceManager
+	httpClient *http.Client
+	// Serialises the refreshes and the configuration changes.
+	lock          sync.Mutex
+	configuration *config.Configuration
+	// Last successfully read sources by file path or url.
+	sources map[string]*discoverySource
+}
+
+// Device entries read from a single file or url.
+type discoverySource struct {
+	data    []byte
+	entries []*config.DiscoveryEntry
+	// If the entries are read from the url.
+	remote bool
+}
+
+// Creates a new discovery for the devices of the manager.
+func newDeviceDiscovery(manager *deviceManager) *deviceDiscovery {
+	return &deviceDiscovery{
+		manager:    manager,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		sources:    make(map[string]*discoverySource),
+	}
+}
+
+// Replaces the configuration and applies the configured and the discovered devices.
+func (discovery *deviceDiscovery) configure(configuration *config.Configuration) {
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+	discovery.configuration = configuration
+	discovery.read()
+	discovery.apply()
+}
+
+// Reads the sources and applies the devices if the entries are changed.
+func (discovery *deviceDiscovery) refresh() {
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+	if discovery.read() {
+		slog.Info("Discovered devices are changed", logging.Operation("discovery"))
+		discovery.apply()
+	}
+}
+
+// Refreshes the discovered devices periodically.
+func (discovery *deviceDiscovery) run() {
+	for {
+		discovery.lock.Lock()
+		interval := time.Duration(discovery.configuration.Discovery.RefreshInterval) * time.Second
+		discovery.lock.Unlock()
+		time.Sleep(interval)
+		discovery.refresh()
+	}
+}
+
+// Reads the files and the url, returns if the entries are changed.
+// The last entries are kept for the sources, which cannot be read or parsed.
+func (discovery *deviceDiscovery) read() bool {
+	configuration := discovery.configuration.Discovery
+	sources := make(map[string]*discoverySource)
+	for _, path := range discoveryFiles(configuration.Files) {
+		data, err := os.ReadFile(path)
+		discovery.update(sources, path, data, err, false)
+	}
+	if configuration.Url != "" {
+		data, err := discovery.fetch(configuration.Url)
+		discovery.update(sources, logging.RedactUrl(configuration.Url), data, err, true)
+	}
+	changed := len(sources) != len(discovery.sources)
+	for name, source := range sources {
+		previous, ok := discovery.sources[name]
+		if !ok || !reflect.DeepEqual(previous.entries, source.entries) {
+			changed = true
+		}
+	}
+	discovery.sources = sources
+	return changed
+}
+
+// Parses the read content of the source, the last entries are kept if it cannot be read or parsed.
+func (discovery *deviceDiscovery) update(sources map[string]*discoverySource, name string, data []byte, err error, remote bool) {
+	previous, ok := discovery.sources[name]
+	if err == nil && ok && slices.Equal(previous.data, data) {
+		sources[name] = previous
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to read discovered devices", logging.Operation("discovery"), slog.String("source", name), logging.Error(err))
+		if ok {
+			sources[name] = previous
+		}
+		return
+	}
+	entries, err := config.ParseDiscoveryEntries(data)
+	if err != nil {
+		slog.Warn("Failed to parse discovered devices", logging.Operation("discovery"), slog.String("source", name), logging.Error(err))
+		// The content is kept, so the same error is not reported again.
+		entries = nil
+		if ok {
+			entries = previous.entries
+		}
+	}
+	sources[name] = &discoverySource{data: data, entries: entries, remote: remote}
+}
+
+// Returns the device entries read from the url.
+func (discovery *deviceDiscovery) fetch(u string) ([]byte, error) {
+	res, err := discovery.httpClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, discoveryBodyLimit))
+}
+
+// Runs the configured devices and the discovered ones, the configured devices take precedence.
+func (discovery *deviceDiscovery) apply() {
+	configuration := discovery.configuration
+	devices := slices.Clone(configuration.Devices)
+	sysIds := make(map[string]bool)
+	for _, device := range devices {
+		sysIds[device.SysId] = true
+	}
+	names := make([]string, 0, len(discovery.sources))
+	for name := range discovery.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source := discovery.sources[name]
+		for _, entry := range source.entries {
+			device, err := configuration.DiscoveredDevice(entry, source.remote)
+			if err != nil {
+				slog.Warn("Discovered device is invalid", logging.Operation("discovery"), slog.String("source", name), logging.Error(err))
+				continue
+			}
+			if sysIds[device.SysId] {
+				slog.Warn("Discovered device is already configured", logging.Operation("discovery"), slog.String("source", name), slog.String("sysId", device.SysId))
+				continue
+			}
+			sysIds[device.SysId] = true
+			devices = append(devices, device)
+		}
+	}
+	discovery.manager.apply(devices, time.Duration(configuration.ShutdownTimeout)*time.Second)
+}
+
+// Returns the sorted files matching the patterns, every yaml and json file is returned from directories.
+func discoveryFiles(patterns []string) []string {
+	files := make([]string, 0)
+	for _, pattern := range patterns {
+		info, err := os.Stat(pattern)
+		if err == nil && info.IsDir() {
+			entries, err := os.ReadDir(pattern)
+			if err != nil {
+				slog.Warn("Failed to read discovery directory", logging.Operation("discovery"), slog.String("path", pattern), logging.Error(err))
+				continue
+			}
+			for _, entry := range entries {
+				ext := filepath.Ext(entry.Name())
+				if !entry.IsDir() && (ext == ".yml" || ext == ".yaml" || ext == ".json") {
+					files = append(files, filepath.Join(pattern, entry.Name()))
+				}
+			}
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			slog.Warn("Invalid discovery file pattern", logging.Operation("discovery"), slog.String("pattern", pattern), logging.Error(err))
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.IsDir() {
+				files = append(files, match)
+			}
+		}
+	}
+	slices.Sort(files)
+	return slices.Compact(files)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/csutorasa/icon-metrics/metrics"
+)
+
+func TestDiscoveryRejectsPasswordFileFromUrl(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	err := os.WriteFile(passwordFile, []byte("secret"), 0600)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "devices.yml"), fmt.Appendf(nil, `
+- url: http://127.0.0.1:1
+  sysid: '123456789012'
+  passwordFile: %s
+`, passwordFile), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"url": "http://127.0.0.1:1", "sysid": "210987654321", "passwordFile": %q}]`, passwordFile)
+	}))
+	defer sd.Close()
+
+	c := parseTestConfig(t, `
+discovery:
+  files: [`+filepath.Join(dir, "devices.yml")+`]
+  url: `+sd.URL+`
+  module: default
+modules:
+  default:
+    targets: ['127.0.0.1:*']
+    mode: scrape
+`)
+	registry := metrics.NewRegistry(c.Collectors)
+	manager := newDeviceManager(metrics.NewPrometheusReporter(registry, c), metrics.NewScrapeTrigger(registry))
+	defer manager.close(t.Context())
+	newDeviceDiscovery(manager).configure(c)
+
+	running := manager.running()
+	if len(running) != 1 || running[0].configuration.SysId != "123456789012" || running[0].configuration.Password != "secret" {
+		t.Errorf("only the device of the file is expected to run, got %d devices", len(running))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+)
+
+// Liveness response body.
+type liveness struct {
+	Status     string `json:"status"`
+	Goroutines int    `json:"goroutines"`
+	// Devices, whose reads are stuck or are not started in time.
+	Stalled []string `json:"stalled"`
+}
+
+// Readiness response body.
+type readiness struct {
+	Status  string         `json:"status"`
+	Ready   string         `json:"ready"`
+	Devices []*deviceState `json:"devices"`
+}
+
+// Health state of a single device.
+type deviceState struct {
+	SysId               string     `json:"sysId"`
+	Connected           bool       `json:"connected"`
+	LastSuccess         *time.Time `json:"lastSuccess"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	// Set if the last successful read is more recent than the max age.
+	Fresh bool `json:"fresh"`
+}
+
+// Serves the liveness and readiness checks of the running devices.
+type healthHandler struct {
+	manager       *deviceManager
+	configuration atomic.Pointer[config.HealthConfiguration]
+}
+
+// Creates a new handler for the health checks.
+func newHealthHandler(configuration *config.Configuration, manager *deviceManager) *healthHandler {
+	handler := &healthHandler{manager: manager}
+	handler.configure(configuration)
+	return handler
+}
+
+// Replaces the readiness criteria.
+func (handler *healthHandler) configure(configuration *config.Configuration) {
+	handler.configuration.Store(configuration.Health)
+}
+
+// Reports if the process is alive and the devices are read.
+func (handler *healthHandler) live(w http.ResponseWriter, r *http.Request) {
+	body := &liveness{
+		Status:     "ok",
+		Goroutines: runtime.NumGoroutine(),
+		Stalled:    handler.manager.stalled(),
+	}
+	status := http.StatusOK
+	if len(body.Stalled) != 0 {
+		body.Status = "failed"
+		status = http.StatusServiceUnavailable
+	}
+	writeJson(w, status, body)
+}
+
+// Reports if the devices are fresh enough to match the readiness criteria.
+func (handler *healthHandler) ready(w http.ResponseWriter, r *http.Request) {
+	configuration := handler.configuration.Load()
+	maxAge := time.Duration(configuration.MaxAge) * time.Second
+	states, closed := handler.manager.states()
+	body := &readiness{
+		Status:  "ready",
+		Ready:   configuration.Ready,
+		Devices: make([]*deviceState, 0, len(states)),
+	}
+	fresh := 0
+	for sysId, state := range states {
+		device := &deviceState{
+			SysId:               sysId,
+			Connected:           state.Connected,
+			ConsecutiveFailures: state.ConsecutiveFailures,
+		}
+		if !state.LastSuccess.IsZero() {
+			device.LastSuccess = &state.LastSuccess
+			device.Fresh = time.Since(state.LastSuccess) <= maxAge
+		}
+		if device.Fresh {
+			fresh++
+		}
+		body.Devices = append(body.Devices, device)
+	}
+	sort.Slice(body.Devices, func(i, j int) bool {
+		return body.Devices[i].SysId < body.Devices[j].SysId
+	})
+	ready := !closed
+	if len(states) != 0 {
+		switch configuration.Ready {
+		case config.ReadyAny:
+			ready = ready && fresh > 0
+		case config.ReadyAll:
+			ready = ready && fresh == len(states)
+		}
+	}
+	status := http.StatusOK
+	if !ready {
+		body.Status = "not ready"
+		status = http.StatusServiceUnavailable
+	}
+	writeJson(w, status, body)
+}
+
+// Writes the value as JSON response.
+func writeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
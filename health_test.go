@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/metrics"
+)
+
+// Session with a fixed health state.
+type stateSession struct {
+	metrics.MetricsSession
+	state metrics.SessionState
+}
+
+func (session *stateSession) State() metrics.SessionState {
+	return session.state
+}
+
+// Returns a manager running devices with the states and progresses by sysid.
+func healthTestManager(states map[string]metrics.SessionState, progresses map[string]*readProgress) *deviceManager {
+	manager := newDeviceManager(nil, nil)
+	for sysId, state := range states {
+		progress, ok := progresses[sysId]
+		if !ok {
+			progress = &readProgress{finished: time.Now()}
+		}
+		manager.runners[sysId] = &deviceRunner{
+			configuration: &config.IconConfiguration{SysId: sysId},
+			session:       &stateSession{state: state},
+			progress:      progress,
+		}
+	}
+	return manager
+}
+
+func TestReadProgressStalled(t *testing.T) {
+	now := time.Now()
+	if (&readProgress{started: now.Add(-30 * time.Second)}).stalled() {
+		t.Error("read within the stall timeout is stalled")
+	}
+	if !(&readProgress{started: now.Add(-2 * stallTimeout)}).stalled() {
+		t.Error("stuck read is not stalled")
+	}
+	if (&readProgress{interval: time.Minute, finished: now.Add(-90 * time.Second)}).stalled() {
+		t.Error("poll within the delay and the stall timeout is stalled")
+	}
+	if !(&readProgress{interval: time.Minute, finished: now.Add(-3 * time.Minute)}).stalled() {
+		t.Error("poll not started in time is not stalled")
+	}
+	if (&readProgress{finished: now.Add(-time.Hour)}).stalled() {
+		t.Error("device read on scrape is stalled without scrapes")
+	}
+}
+
+func TestLive(t *testing.T) {
+	manager := healthTestManager(
+		map[string]metrics.SessionState{"123456789012": {}, "210987654321": {}},
+		map[string]*readProgress{"210987654321": {started: time.Now().Add(-2 * stallTimeout)}},
+	)
+	handler := newHealthHandler(&config.Configuration{Health: &config.HealthConfiguration{}}, manager)
+
+	recorder := httptest.NewRecorder()
+	handler.live(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	body := &liveness{}
+	err := json.NewDecoder(recorder.Body).Decode(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusServiceUnavailable || !slices.Equal(body.Stalled, []string{"210987654321"}) {
+		t.Errorf("stalled device is not reported, got %d %+v", recorder.Code, body)
+	}
+
+	manager.runners["210987654321"].progress.finish()
+	recorder = httptest.NewRecorder()
+	handler.live(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected %d after the read is finished, got %d", http.StatusOK, recorder.Code)
+	}
+}
+
+func TestReady(t *testing.T) {
+	fresh := metrics.SessionState{Connected: true, LastSuccess: time.Now()}
+	old := metrics.SessionState{Connected: true, LastSuccess: time.Now().Add(-time.Hour)}
+	never := metrics.SessionState{ConsecutiveFailures: 3}
+	tests := map[string]struct {
+		ready    string
+		states   map[string]metrics.SessionState
+		closed   bool
+		expected int
+	}{
+		"no devices":           {ready: config.ReadyAny, expected: http.StatusOK},
+		"any with one fresh":   {ready: config.ReadyAny, states: map[string]metrics.SessionState{"1": fresh, "2": never}, expected: http.StatusOK},
+		"any without fresh":    {ready: config.ReadyAny, states: map[string]metrics.SessionState{"1": old, "2": never}, expected: http.StatusServiceUnavailable},
+		"all with every fresh": {ready: config.ReadyAll, states: map[string]metrics.SessionState{"1": fresh, "2": fresh}, expected: http.StatusOK},
+		"all with one old":     {ready: config.ReadyAll, states: map[string]metrics.SessionState{"1": fresh, "2": old}, expected: http.StatusServiceUnavailable},
+		"shutting down":        {ready: config.ReadyAny, closed: true, expected: http.StatusServiceUnavailable},
+	}
+	for name, test := range tests {
+		manager := healthTestManager(test.states, nil)
+		manager.closed = test.closed
+		handler := newHealthHandler(&config.Configuration{Health: &config.HealthConfiguration{Ready: test.ready, MaxAge: 60}}, manager)
+		recorder := httptest.NewRecorder()
+		handler.ready(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if recorder.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d: %s", name, test.expected, recorder.Code, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/model"
+	"golang.org/x/term"
+)
+
+// Report flag of the generated configuration.
+type initReportFlag struct {
+	Name    string
+	Comment string
+	Enabled bool
+}
+
+// Excluded room of the generated configuration.
+type initRoom struct {
+	Id string
+	// Name of the room, empty if the device is not read.
+	Name string
+}
+
+// Settings of the generated configuration.
+type initSettings struct {
+	Port         int
+	Url          string
+	SysId        string
+	Password     string
+	PasswordFile string
+	Report       []*initReportFlag
+	Rooms        []*initRoom
+}
+
+// Report flags in the order of the configuration file, every metric is reported by default.
+var initReportFlags = []initReportFlag{
+	{Name: "controllerConnected", Comment: "if icon_controller_connected metric is reported"},
+	{Name: "httpClient", Comment: "if icon_http_client_seconds, icon_http_client_request_size_bytes and icon_http_client_response_size_bytes metrics are reported"},
+	{Name: "externalTemperature", Comment: "if icon_external_temperature metric is reported"},
+	{Name: "waterTemperature", Comment: "if icon_water_temperature metric is reported"},
+	{Name: "heating", Comment: "if icon_heating metric is reported"},
+	{Name: "eco", Comment: "if icon_eco metric is reported"},
+	{Name: "roomConnected", Comment: "if icon_room_connected metric is reported"},
+	{Name: "temperature", Comment: "if icon_temperature metric is reported"},
+	{Name: "relay", Comment: "if icon_relay_on metric is reported"},
+	{Name: "humidity", Comment: "if icon_humidity metric is reported"},
+	{Name: "targetTemperature", Comment: "if icon_target_temperature metric is reported"},
+	{Name: "dewTemperature", Comment: "if icon_dew_temperature metric is reported"},
+	{Name: "roomChanges", Comment: "if icon_room_changes_total metric is reported"},
+	{Name: "health", Comment: "if icon_last_attempt_timestamp_seconds, icon_last_success_timestamp_seconds, icon_consecutive_failures, icon_polls_total, icon_poll_cycle_seconds and icon_stale metrics are reported"},
+	{Name: "roomInfo", Comment: "if icon_room_info metric is reported"},
+}
+
+// Template of the generated configuration.
+var initTemplate = template.Must(template.New("config").Funcs(template.FuncMap{
+	"quote":   yamlQuote,
+	"comment": yamlComment,
+}).Parse(`# Generated by icon-metrics init, see config.schema.json for every setting
+port: {{ .Port }} # http server port to host metrics on
+devices:
+  - url: {{ quote .Url }} # device address
+    sysid: {{ quote .SysId }} # device ID (printed on the controller)
+{{- if .PasswordFile }}
+    passwordFile: {{ quote .PasswordFile }} # file to read the password from instead of password
+{{- else if .Password }}
+    password: {{ quote .Password }} # password
+{{- else }}
+    #password: '123123123123' # password (same as sysid if empty)
+{{- end }}
+    delay: 15 # delay in seconds between reads
+    report: # reported metrics configuration
+{{- range .Report }}
+      {{ .Name }}: {{ .Enabled }} # {{ .Comment }}
+{{- end }}
+{{- if .Rooms }}
+    rooms: # room specific configuration, matching entries are applied in order
+{{- range .Rooms }}
+      - id: {{ quote .Id }} # {{ if .Name }}{{ comment .Name }}{{ else }}room id{{ end }}
+        exclude: true # if the room is not reported at all
+{{- end }}
+{{- end }}
+`))
+
+// Writes a configuration file with a single device, asks for the settings unless it is non-interactive.
+func initCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	port := flags.Int("port", 80, "Http server port to host metrics on")
+	url := flags.String("url", "", "Url of the device")
+	sysId := flags.String("sysid", "", "System ID of the device")
+	passwordFile := flags.String("password-file", "", "File to read the password of the device from, asked for unless it is non-interactive")
+	disable := flags.String("disable", "", "Comma separated report flags to disable, like humidity,relay")
+	exclude := flags.String("exclude-rooms", "", "Comma separated room ids to exclude")
+	nonInteractive := flags.Bool("non-interactive", false, "Writes the configuration from the flags without asking")
+	skipLogin := flags.Bool("skip-login", false, "Does not log in to the device to confirm the credentials")
+	force := flags.Bool("force", false, "Overwrites the existing configuration file")
+	return func() error {
+		path := resolveConfigPath(*configPath)
+		settings := &initSettings{
+			Port:         *port,
+			Url:          *url,
+			SysId:        *sysId,
+			PasswordFile: *passwordFile,
+		}
+		report, err := initReport(*disable)
+		if err != nil {
+			return err
+		}
+		settings.Report = report
+		if !*nonInteractive {
+			return runInitWizard(path, settings, *disable, *exclude, *skipLogin, *force)
+		}
+		if settings.Url == "" || settings.SysId == "" {
+			return errors.New("url and sysid flags are required in non-interactive mode")
+		}
+		if _, err := os.Stat(path); err == nil && !*force {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+		}
+		values, err := initReadValues(settings, *skipLogin)
+		if err != nil {
+			return err
+		}
+		settings.Rooms, err = initRooms(*exclude, values)
+		if err != nil {
+			return err
+		}
+		return writeInitConfig(path, settings)
+	}
+}
+
+// Asks for the settings, confirms the credentials and writes the configuration.
+func runInitWizard(path string, settings *initSettings, disable string, exclude string, skipLogin bool, force bool) error {
+	p := &prompter{reader: bufio.NewReader(os.Stdin), out: os.Stdout}
+	fmt.Fprintf(p.out, "This wizard writes the configuration file %s\n\n", path)
+	if _, err := os.Stat(path); err == nil && !force {
+		overwrite, err := p.confirm(path+" already exists, overwrite it?", false)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			return errors.New("configuration is not written")
+		}
+	}
+	var values *model.DataPollResponse
+	for {
+		var err error
+		if settings.Url, err = p.askRequired("Controller url, like http://192.168.1.10", settings.Url); err != nil {
+			return err
+		}
+		if settings.SysId, err = p.askRequired("System ID, printed on the controller", settings.SysId); err != nil {
+			return err
+		}
+		if settings.PasswordFile == "" {
+			if settings.Password, err = p.askPassword("Password, empty if it is the same as the system ID"); err != nil {
+				return err
+			}
+		}
+		values, err = initReadValues(settings, skipLogin)
+		if err == nil {
+			break
+		}
+		fmt.Fprintf(p.out, "%s\n", err.Error())
+		retry, err := p.confirm("Try again?", true)
+		if err != nil {
+			return err
+		}
+		if !retry {
+			return errors.New("configuration is not written")
+		}
+	}
+	if values != nil {
+		fmt.Fprintf(p.out, "\nConnected to %s, firmware version %s\n\n", settings.SysId, values.Version)
+		printInitRooms(p.out, values)
+	}
+	fmt.Fprintf(p.out, "\nEvery metric is reported by default, the report flags are:\n")
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	for _, reportFlag := range initReportFlags {
+		fmt.Fprintf(w, "  %s\t%s\n", reportFlag.Name, reportFlag.Comment)
+	}
+	w.Flush()
+	for {
+		answer, err := p.ask("Report flags to disable, comma separated", disable)
+		if err != nil {
+			return err
+		}
+		settings.Report, err = initReport(answer)
+		if err == nil {
+			break
+		}
+		fmt.Fprintf(p.out, "%s\n", err.Error())
+	}
+	for {
+		answer, err := p.ask("Room ids to exclude, comma separated", exclude)
+		if err != nil {
+			return err
+		}
+		settings.Rooms, err = initRooms(answer, values)
+		if err == nil {
+			break
+		}
+		fmt.Fprintf(p.out, "%s\n", err.Error())
+	}
+	for {
+		answer, err := p.ask("Http server port to host metrics on", strconv.Itoa(settings.Port))
+		if err != nil {
+			return err
+		}
+		port, err := strconv.Atoi(answer)
+		if err == nil && port > 0 && port <= 65535 {
+			settings.Port = port
+			break
+		}
+		fmt.Fprintf(p.out, "invalid port %s\n", answer)
+	}
+	fmt.Fprintln(p.out)
+	return writeInitConfig(path, settings)
+}
+
+// Validates the settings and reads the values of the device, the values are nil if the login is skipped.
+func initReadValues(settings *initSettings, skipLogin bool) (*model.DataPollResponse, error) {
+	c, err := parseInitConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	if skipLogin {
+		return nil, nil
+	}
+	return readDevice(c, c.Devices[0])
+}
+
+// Returns the report flags with the comma separated flags disabled.
+func initReport(disable string) ([]*initReportFlag, error) {
+	disabled := make(map[string]bool)
+	for _, name := range splitList(disable) {
+		disabled[name] = true
+	}
+	report := make([]*initReportFlag, 0, len(initReportFlags))
+	for _, reportFlag := range initReportFlags {
+		reportFlag.Enabled = !disabled[reportFlag.Name]
+		delete(disabled, reportFlag.Name)
+		report = append(report, &reportFlag)
+	}
+	for name := range disabled {
+		return nil, fmt.Errorf("unknown report flag %s", name)
+	}
+	return report, nil
+}
+
+// Returns the comma separated rooms, the rooms must exist on the device if its values are read.
+func initRooms(exclude string, values *model.DataPollResponse) ([]*initRoom, error) {
+	rooms := make([]*initRoom, 0)
+	for _, id := range splitList(exclude) {
+		room := &initRoom{Id: id}
+		if values != nil {
+			thermostat, ok := values.Thermostats[id]
+			if !ok {
+				return nil, fmt.Errorf("room %s is not found on the device", id)
+			}
+			room.Name = thermostat.Name
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+// Prints the enabled rooms of the device.
+func printInitRooms(out io.Writer, values *model.DataPollResponse) {
+	ids := make([]string, 0, len(values.Thermostats))
+	for id, thermostat := range values.Thermostats {
+		if thermostat.Enabled != 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return roomIdLess(ids[i], ids[j])
+	})
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOM\tNAME")
+	for _, id := range ids {
+		fmt.Fprintf(w, "%s\t%s\n", id, values.Thermostats[id].Name)
+	}
+	w.Flush()
+}
+
+// Renders and validates the configuration.
+func parseInitConfig(settings *initSettings) (*config.Configuration, error) {
+	data, err := renderInitConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	return config.ParseConfig(data)
+}
+
+// Renders the configuration file content.
+func renderInitConfig(settings *initSettings) ([]byte, error) {
+	builder := &strings.Builder{}
+	err := initTemplate.Execute(builder, settings)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(builder.String()), nil
+}
+
+// Writes the validated configuration, only the owner can read it if it contains the password.
+func writeInitConfig(path string, settings *initSettings) error {
+	data, err := renderInitConfig(settings)
+	if err != nil {
+		return err
+	}
+	_, err = config.ParseConfig(data)
+	if err != nil {
+		return err
+	}
+	var perm os.FileMode = 0644
+	if settings.Password != "" {
+		perm = 0600
+	}
+	err = os.WriteFile(path, data, perm)
+	if err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	fmt.Printf("Configuration is written to %s\n", path)
+	return nil
+}
+
+// Returns the non-empty trimmed items of the comma separated list.
+func splitList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Returns the value as a single quoted yaml string.
+func yamlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// Returns the value in a single line, so it can be written as a yaml comment.
+func yamlComment(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// Reads the answers from the terminal.
+type prompter struct {
+	reader *bufio.Reader
+	out    io.Writer
+}
+
+// Asks the question and returns the answer, the default value if the answer is empty.
+func (p *prompter) ask(question string, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.New("input is closed, configuration is not written")
+	}
+	answer := strings.TrimSpace(line)
+	if answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+// Asks the question until the answer is not empty.
+func (p *prompter) askRequired(question string, defaultValue string) (string, error) {
+	for {
+		answer, err := p.ask(question, defaultValue)
+		if err != nil || answer != "" {
+			return answer, err
+		}
+	}
+}
+
+// Asks the question without echoing the answer on terminals.
+func (p *prompter) askPassword(question string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return p.ask(question, "")
+	}
+	fmt.Fprintf(p.out, "%s: ", question)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(p.out)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimSpace(string(password)), nil
+}
+
+// Asks the yes or no question.
+func (p *prompter) confirm(question string, defaultValue bool) (bool, error) {
+	options := "y/N"
+	if defaultValue {
+		options = "Y/n"
+	}
+	for {
+		answer, err := p.ask(question+" ["+options+"]", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return defaultValue, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
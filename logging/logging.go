@@ -0,0 +1,99 @@
+// Structured logging setup.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+
+	"github.com/csutorasa/icon-metrics/config"
+)
+
+// Log level of the default logger, which can be changed at runtime.
+var level = new(slog.LevelVar)
+
+// Sets the default logger based on the configuration.
+func Configure(configuration *config.LogConfiguration) error {
+	l, err := parseLevel(configuration.Level)
+	if err != nil {
+		return err
+	}
+	output, err := openOutput(configuration.Output)
+	if err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch configuration.Format {
+	case config.LogFormatJson:
+		handler = slog.NewJSONHandler(output, options)
+	case config.LogFormatText:
+		handler = slog.NewTextHandler(output, options)
+	default:
+		return fmt.Errorf("unknown log format %s", configuration.Format)
+	}
+	level.Set(l)
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Changes the level of the default logger.
+func SetLevel(configuration *config.LogConfiguration) error {
+	l, err := parseLevel(configuration.Level)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Returns the level from its name.
+func parseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(name))
+	if err != nil {
+		return l, fmt.Errorf("unknown log level %s", name)
+	}
+	return l, nil
+}
+
+// Returns the writer of the output, which is either stdout, stderr or a file path.
+func openOutput(output string) (io.Writer, error) {
+	switch output {
+	case config.LogOutputStdout:
+		return os.Stdout, nil
+	case config.LogOutputStderr:
+		return os.Stderr, nil
+	}
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return file, nil
+}
+
+// Returns the logger of a device.
+func Device(sysId string, rawUrl string) *slog.Logger {
+	return slog.With(slog.String("sysId", sysId), slog.String("url", RedactUrl(rawUrl)))
+}
+
+// Returns the operation attribute.
+func Operation(operation string) slog.Attr {
+	return slog.String("operation", operation)
+}
+
+// Returns the error attribute.
+func Error(err error) slog.Attr {
+	return slog.String("error", err.Error())
+}
+
+// Removes the password from the url.
+func RedactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	return u.Redacted()
+}
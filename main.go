@@ -2,185 +2,333 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/csutorasa/icon-metrics/client"
 	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/logging"
 	"github.com/csutorasa/icon-metrics/metrics"
 )
 
-// Main logger instance
-var logger *log.Logger = log.Default()
-
 func main() {
-	configPath := parseArgs()
+	cmd, args := findCommand(os.Args[1:])
+	if cmd == nil {
+		serve(os.Args[1:])
+		return
+	}
+	flags := commandFlags(cmd.name)
+	run := cmd.setup(flags)
+	flags.Parse(args)
+	err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// Reads the devices and serves the metrics until shutdown.
+func serve(args []string) {
+	configPath, watchInterval := parseArgs(args)
 
 	c, err := readConfig(configPath)
 	if err != nil {
-		logger.Panicf("Failed to load configuration caused by %s", err.Error())
+		slog.Error("Failed to load configuration", logging.Operation("config"), logging.Error(err))
+		os.Exit(1)
+	}
+	err = logging.Configure(c.Log)
+	if err != nil {
+		slog.Error("Failed to configure logging", logging.Operation("config"), logging.Error(err))
+		os.Exit(1)
 	}
 
-	reporter := metrics.NewPrometheusReporter()
-	reporter.Uptime()
+	registry := metrics.NewRegistry(c.Collectors)
+	reporter := metrics.NewPrometheusReporter(registry, c)
+	reporter.StartTime()
+	if *c.Compatibility.Uptime {
+		reporter.Uptime()
+	}
 
-	logger.Printf("Starting http server on port %d", c.Port)
+	_, address := c.ListenAddress()
+	slog.Info("Starting http server", logging.Operation("serve"), slog.String("address", address))
 	start := metrics.NewTimer()
-	p := metrics.NewPrometheusPublisher(c.Port)
+	trigger := metrics.NewScrapeTrigger(registry)
+	p := metrics.NewPrometheusPublisher(c, registry, trigger)
+	probe := newProbeHandler(c)
+	if *c.Probe.Enabled {
+		p.Handle("GET /probe", probe)
+	}
+	manager := newDeviceManager(reporter, trigger)
+	health := newHealthHandler(c, manager)
+	p.Handle("GET /healthz", http.HandlerFunc(health.live))
+	p.Handle("GET /readyz", http.HandlerFunc(health.ready))
 
 	err = p.Start()
 	if err != nil {
-		logger.Panicf("Failed to start http server on port %d caused by %s", c.Port, err.Error())
+		slog.Error("Failed to start http server", logging.Operation("serve"), slog.String("address", address), logging.Error(err))
+		os.Exit(1)
 	}
-	defer func() {
+	slog.Info("Successfully started http server", logging.Operation("serve"), slog.String("address", address), slog.Duration("duration", start.End()))
+
+	if c.Admin.Enabled() {
+		_, adminAddress := c.Admin.ListenAddress()
+		admin := newAdminServer(c.Admin, manager)
+		err = admin.start()
+		if err != nil {
+			slog.Error("Failed to start admin http server", logging.Operation("serve"), slog.String("address", adminAddress), logging.Error(err))
+			os.Exit(1)
+		}
+		// The admin server is stopped last, so the shutdown can be inspected.
+		defer admin.close()
+		slog.Info("Successfully started admin http server", logging.Operation("serve"), slog.String("address", adminAddress))
+	}
+
+	discovery := newDeviceDiscovery(manager)
+	discovery.configure(c)
+	go discovery.run()
+	reloader := newConfigReloader(configPath, c, discovery, probe, health, metrics.NewReloadReporter(registry))
+	if watchInterval > 0 {
+		go reloader.watch(watchInterval)
+	}
+	shutdown := make(chan struct{})
+	signalHandler(shutdown, reloader)
+	<-shutdown
+	timeout := time.Duration(reloader.current().ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stop(ctx, address, p, manager)
+}
+
+// Drains the in-flight requests and disconnects from the devices in parallel until the context is done.
+// The logouts are not delayed by the slow requests, so the device sessions are not left open.
+func stop(ctx context.Context, address string, p metrics.PrometheusPublisher, manager *deviceManager) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
 		start := metrics.NewTimer()
-		logger.Printf("Stopping http server on port %d", c.Port)
-		p.Close()
-		logger.Printf("Successfully stopped http server on port %d under %s", c.Port, start.End().String())
-	}()
-	logger.Printf("Successfully started http server on port %d under %s", c.Port, start.End().String())
-
-	var wg sync.WaitGroup
-	channels := make([]chan int, 0)
-	for _, device := range c.Devices {
-		reportConfig := device.Report
-		session := metrics.NewSession(device.SysId, reportConfig, reporter)
-		client, err := client.NewIconClient(device.Url, device.SysId, device.Password, session)
+		slog.Info("Disconnecting from the devices", logging.Operation("shutdown"))
+		err := manager.close(ctx)
 		if err != nil {
-			logger.Printf("Failed to create client for device %s @ %s", device.SysId, device.Url)
-			continue
+			slog.Warn("Failed to disconnect from every device before the deadline", logging.Operation("shutdown"), logging.Error(err))
+		} else {
+			slog.Info("Successfully disconnected from the devices", logging.Operation("shutdown"), slog.Duration("duration", start.End()))
 		}
-		delay := time.Duration(device.Delay) * time.Second
-		ch := make(chan int)
-		channels = append(channels, ch)
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			defer func() {
-				start := metrics.NewTimer()
-				logger.Printf("Disonnecting from %s", client.SysId())
-				err := client.Close()
-				reporter.RemoveDevice(client.SysId())
-				if err != nil {
-					logger.Printf("Failed to disonnect from %s caused by %s", client.SysId(), err.Error())
-				} else {
-					logger.Printf("Successfully disconnected from %s under %s", client.SysId(), start.End().String())
-				}
-			}()
-			reportValues(client, ch, delay, session)
-		}()
+	}()
+	start := metrics.NewTimer()
+	slog.Info("Stopping http server", logging.Operation("shutdown"), slog.String("address", address))
+	err := p.Stop(ctx)
+	if err != nil {
+		slog.Warn("Failed to stop http server gracefully", logging.Operation("shutdown"), slog.String("address", address), logging.Error(err))
+		p.Close()
+	} else {
+		slog.Info("Successfully stopped http server", logging.Operation("shutdown"), slog.String("address", address), slog.Duration("duration", start.End()))
 	}
-	if len(channels) != 0 {
-		interruptHandler(channels)
-		wg.Wait()
+	wg.Wait()
+}
+
+// Parses configuration file path and watch interval from command line options
+func parseArgs(args []string) (string, time.Duration) {
+	flags := flag.NewFlagSet("icon-metrics", flag.ExitOnError)
+	flags.Usage = func() {
+		printUsage(flags)
 	}
+	configPath, watchInterval := serverFlags(flags)
+	flags.Parse(args)
+	return resolveConfigPath(*configPath), *watchInterval
+}
+
+// Registers the flags of the server.
+func serverFlags(flags *flag.FlagSet) (*string, *time.Duration) {
+	configPath := configFlag(flags)
+	watchInterval := flags.Duration("watch", 0, "Interval to check the configuration file for changes, disabled if 0")
+	return configPath, watchInterval
 }
 
-// Parses configuration file path from command line options
-func parseArgs() string {
-	configPath := flag.String("config", "", "Configuration file url")
-	flag.Parse()
-	if *configPath == "" {
+// Registers the configuration file path flag.
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", "", "Configuration file url")
+}
+
+// Returns the configuration file path, defaults to config.yml next to the executable.
+func resolveConfigPath(configPath string) string {
+	if configPath == "" {
 		dir := filepath.Dir(os.Args[0])
 		return filepath.Join(dir, "config.yml")
 	}
-	return *configPath
+	return configPath
 }
 
 // Returns configuration from file.
 func readConfig(configPath string) (*config.Configuration, error) {
-	logger.Printf("Loading configuration from %s", configPath)
+	slog.Info("Loading configuration", logging.Operation("config"), slog.String("path", configPath))
 	c, err := config.ReadConfig(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
-	logger.Printf("Configuration is loaded from %s", configPath)
+	warnIgnoredEnvironment(c)
+	slog.Info("Configuration is loaded", logging.Operation("config"), slog.String("path", configPath))
 	return c, nil
 }
 
-// Handles OS signals for shutdown.
-func interruptHandler(channels []chan int) {
+// Logs the environment variables, which do not match a setting.
+func warnIgnoredEnvironment(c *config.Configuration) {
+	for _, name := range c.IgnoredEnvironment {
+		slog.Warn("Unknown environment variable is ignored", logging.Operation("config"), slog.String("name", name))
+	}
+}
+
+// Handles OS signals for shutdown and configuration reload.
+// SIGINT and SIGTERM initiate the graceful shutdown, the second one forces the exit.
+func signalHandler(shutdown chan struct{}, reloader *configReloader) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGABRT)
-	closing := false
+	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGABRT, syscall.SIGHUP)
+	var closing atomic.Bool
 	go func() {
 		for {
-			switch <-c {
-			case syscall.SIGINT:
-				if !closing {
-					closing = true
-					logger.Printf("SIGINT received, graceful shutdown initiated")
-					for _, c := range channels {
-						ch := c
-
-						go func() {
-							ch <- 1
-						}()
-					}
+			switch s := <-c; s {
+			case syscall.SIGHUP:
+				slog.Info("SIGHUP received, configuration reload initiated", logging.Operation("signal"))
+				go reloader.reload()
+			case syscall.SIGINT, syscall.SIGTERM:
+				name := signalName(s)
+				if closing.CompareAndSwap(false, true) {
+					slog.Info(name+" received, graceful shutdown initiated", logging.Operation("signal"))
+					close(shutdown)
 				} else {
-					logger.Printf("SIGINT received again, force shutdown initiated")
+					slog.Warn(name+" received again, force shutdown initiated", logging.Operation("signal"))
 					os.Exit(0)
 				}
-			case syscall.SIGTERM:
-				logger.Printf("SIGTERM received, force shutdown initiated")
-				os.Exit(0)
 			case syscall.SIGABRT:
-				logger.Printf("SIGABRT received, force shutdown initiated")
+				slog.Warn("SIGABRT received, force shutdown initiated", logging.Operation("signal"))
 				os.Exit(0)
 			}
 		}
 	}()
 }
 
+// Returns the name of the shutdown signal.
+func signalName(s os.Signal) string {
+	if s == syscall.SIGTERM {
+		return "SIGTERM"
+	}
+	return "SIGINT"
+}
+
 // Main loop for handling a single iCON device.
-func reportValues(c client.IconClient, trigger chan int, d time.Duration, session metrics.MetricsSession) {
+func reportValues(c client.IconClient, stop chan struct{}, updates chan *config.IconConfiguration, d time.Duration, session metrics.MetricsSession, progress *readProgress) {
 	session.Connected(false)
 	for {
-		if !c.IsLoggedIn() {
-			logger.Printf("Connecting to %s", c.SysId())
-			err := c.Login()
-			if err != nil {
-				logger.Printf("Failed to connect to %s caused by %s", c.SysId(), err.Error())
-				session.Reset()
-				value := sleep(trigger, d)
-				if value > 0 {
-					break
-				}
-				continue
-			}
-			logger.Printf("Connected to %s", c.SysId())
-			session.Connected(true)
+		progress.start()
+		poll(c, session)
+		progress.finish()
+		select {
+		case <-stop:
+			return
+		case configuration := <-updates:
+			session.Configure(configuration)
+			progress.configure(configuration)
+			d = time.Duration(*configuration.Delay) * time.Second
+		case <-time.After(d):
 		}
-		values, err := c.ReadValues()
+	}
+}
+
+// Reads and reports values from a single iCON device.
+func poll(c client.IconClient, session metrics.MetricsSession) error {
+	timer := metrics.NewTimer()
+	defer func() {
+		session.PollCycle(timer.End())
+	}()
+	logger := c.Logger()
+	if !c.IsLoggedIn() {
+		logger.Info("Connecting", logging.Operation(metrics.StageLogin))
+		err := c.Login()
 		if err != nil {
-			logger.Printf("Failed to read values from %s caused by %s", c.SysId(), err.Error())
-			session.Reset()
-			value := sleep(trigger, d)
-			if value > 0 {
-				break
-			}
-			continue
-		}
-		session.Report(values)
-		value := sleep(trigger, d)
-		if value > 0 {
-			break
+			logger.Warn("Failed to connect", logging.Operation(metrics.StageLogin), logging.Error(err))
+			session.PollFailed(metrics.StageLogin)
+			return err
 		}
+		logger.Info("Connected", logging.Operation(metrics.StageLogin))
+		session.PollSucceeded(metrics.StageLogin)
+		session.Connected(true)
+	}
+	values, err := c.ReadValues()
+	if err != nil {
+		logger.Warn("Failed to read values", logging.Operation(metrics.StageRead), logging.Error(err))
+		session.PollFailed(metrics.StageRead)
+		return err
 	}
+	session.PollSucceeded(metrics.StageRead)
+	session.Report(values)
+	return nil
 }
 
-// Sleeps for the duration, which can be interrupted.
-func sleep(trigger chan int, d time.Duration) int {
-	go func() {
-		time.Sleep(d)
-		trigger <- 0
-	}()
-	return <-trigger
+// Reads values from a single iCON device when the metrics are scraped.
+type scrapeRefresher struct {
+	client      client.IconClient
+	minInterval time.Duration
+	session     metrics.MetricsSession
+	progress    *readProgress
+	// Serialises the reads, so concurrent scrapes are deduplicated.
+	lock sync.Mutex
+	// Time of the last read.
+	lastPoll time.Time
+	// Set after the device is stopped, the scrapes in progress do not read it anymore.
+	stopped bool
+}
+
+// Creates a new refresher for the device.
+func newScrapeRefresher(c client.IconClient, minInterval time.Duration, session metrics.MetricsSession, progress *readProgress) *scrapeRefresher {
+	return &scrapeRefresher{
+		client:      c,
+		minInterval: minInterval,
+		session:     session,
+		progress:    progress,
+	}
+}
+
+// Reads and reports values, unless the last read is more recent than the minimum interval.
+func (refresher *scrapeRefresher) refresh() {
+	refresher.lock.Lock()
+	defer refresher.lock.Unlock()
+	if refresher.stopped || time.Since(refresher.lastPoll) < refresher.minInterval {
+		return
+	}
+	refresher.progress.start()
+	poll(refresher.client, refresher.session)
+	refresher.progress.finish()
+	refresher.lastPoll = time.Now()
+}
+
+// Applies the configuration changes until the device is stopped.
+func (refresher *scrapeRefresher) wait(stop chan struct{}, updates chan *config.IconConfiguration) {
+	refresher.lock.Lock()
+	refresher.session.Connected(false)
+	refresher.lock.Unlock()
+	for {
+		select {
+		case <-stop:
+			refresher.lock.Lock()
+			defer refresher.lock.Unlock()
+			refresher.stopped = true
+			return
+		case configuration := <-updates:
+			refresher.lock.Lock()
+			refresher.session.Configure(configuration)
+			refresher.minInterval = time.Duration(*configuration.MinInterval) * time.Second
+			refresher.lastPoll = time.Time{}
+			refresher.lock.Unlock()
+		}
+	}
 }
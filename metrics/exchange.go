@@ -0,0 +1,31 @@
+package metrics
+
+import "time"
+
+// HTTP client request and response data.
+type HttpExchange struct {
+	// Name of the called endpoint.
+	Name string
+	// Response status code, 0 if there was no response.
+	StatusCode int
+	// Classification of the failure, empty if the request succeeded.
+	Error string
+	// Size of the request body in bytes.
+	RequestSize int
+	// Size of the response body in bytes.
+	ResponseSize int
+	timer        Timer
+}
+
+// Creates a new exchange and starts measuring its duration.
+func NewHttpExchange(name string) *HttpExchange {
+	return &HttpExchange{
+		Name:  name,
+		timer: NewTimer(),
+	}
+}
+
+// Returns the duration since the exchange was created.
+func (exchange *HttpExchange) Duration() time.Duration {
+	return exchange.timer.End()
+}
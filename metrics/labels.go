@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"slices"
+	"sync"
+)
+
+// Custom labels of the devices and rooms.
+type customLabels struct {
+	// Device label names, added to every series.
+	deviceNames []string
+	// Room label names, added to room series.
+	roomNames []string
+	lock      sync.RWMutex
+	// Device label values by sysId.
+	devices map[string]map[string]string
+	// Room label values by sysId and room id.
+	rooms map[string]map[string]map[string]string
+}
+
+// Creates a new custom label holder with the given label names.
+func newCustomLabels(deviceNames []string, roomNames []string) *customLabels {
+	return &customLabels{
+		deviceNames: deviceNames,
+		roomNames:   roomNames,
+		devices:     make(map[string]map[string]string),
+		rooms:       make(map[string]map[string]map[string]string),
+	}
+}
+
+// Sets the label values of a device and its rooms.
+func (l *customLabels) set(sysId string, labels map[string]string, roomLabels map[string]map[string]string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.devices[sysId] = labels
+	l.rooms[sysId] = roomLabels
+}
+
+// Returns the device label names appended to the parameters.
+func (l *customLabels) deviceParameters(parameters ...string) []string {
+	return slices.Concat(parameters, l.deviceNames)
+}
+
+// Returns the device and room label names appended to the parameters.
+func (l *customLabels) roomParameters(parameters ...string) []string {
+	return slices.Concat(parameters, l.deviceNames, l.roomNames)
+}
+
+// Returns the device label values appended to the values.
+func (l *customLabels) deviceValues(sysId string, values ...string) []string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return appendValues(values, l.deviceNames, l.devices[sysId])
+}
+
+// Returns the device and room label values appended to the values.
+func (l *customLabels) roomValues(sysId string, id string, values ...string) []string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	values = appendValues(values, l.deviceNames, l.devices[sysId])
+	return appendValues(values, l.roomNames, l.rooms[sysId][id])
+}
+
+// Appends the label values in the order of the names, missing values are empty.
+func appendValues(values []string, names []string, labels map[string]string) []string {
+	result := slices.Clone(values)
+	for _, name := range names {
+		result = append(result, labels[name])
+	}
+	return result
+}
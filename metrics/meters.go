@@ -5,22 +5,36 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/csutorasa/icon-metrics/config"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type MetricsReporter interface {
+	// Registers application start time metric
+	StartTime()
 	// Registers application uptime metric
 	Uptime()
+	// Sets the custom labels of a device and its rooms.
+	SetLabels(sysId string, labels map[string]string, roomLabels map[string]map[string]string)
 	SystemMetricsReporter
 	RoomMetricsReporter
 	HttpMetricsReporter
+	HealthMetricsReporter
+}
+
+// Registers application start time metric
+func (r *metricsReporter) StartTime() {
+	r.factory.NewGauge(prometheus.GaugeOpts{
+		Name: "icon_metrics_start_time_seconds",
+		Help: "Start time of the service since unix epoch in seconds",
+	}).Set(float64(r.startTime.UnixMilli()) / 1000)
 }
 
 // Registers application uptime metric
 func (r *metricsReporter) Uptime() {
-	startTime := time.Now()
-	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	startTime := r.startTime
+	r.factory.NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "uptime",
 		Help: "Uptime for the service",
 	}, func() float64 { return float64(time.Duration(time.Since(startTime).Milliseconds())) })
@@ -45,6 +59,7 @@ type SystemMetricsReporter interface {
 }
 
 type systemMetricsReporter struct {
+	labels                   *customLabels
 	connectedGauge           *prometheus.GaugeVec
 	waterTemperatureGauge    *prometheus.GaugeVec
 	externalTemperatureGauge *prometheus.GaugeVec
@@ -52,33 +67,35 @@ type systemMetricsReporter struct {
 	ecoGauge                 *prometheus.GaugeVec
 }
 
-func newSystemPrometheusReporter() SystemMetricsReporter {
+func newSystemPrometheusReporter(factory promauto.Factory, labels *customLabels) SystemMetricsReporter {
+	parameters := labels.deviceParameters(genericParameters...)
 	return &systemMetricsReporter{
-		connectedGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		labels: labels,
+		connectedGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_controller_connected",
 			Help: "For each controller, reports 1 if the controller is ready to be read, 0 otherwise.",
-		}, genericParameters),
-		waterTemperatureGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		waterTemperatureGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_water_temperature",
 			Help: "For each controller, reports the cooling or heating water temperature",
-		}, genericParameters),
-		externalTemperatureGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		externalTemperatureGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_external_temperature",
 			Help: "For each controller, reports external temperature",
-		}, genericParameters),
-		heatingGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		heatingGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_heating",
 			Help: "For each controller, reports 1 if the controller is set to heating mode, 0 otherwise",
-		}, genericParameters),
-		ecoGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		ecoGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_eco",
 			Help: "For each controller, reports 1 if the controller is in economy mode, 0 otherwise",
-		}, genericParameters),
+		}, parameters),
 	}
 }
 
 func (r *systemMetricsReporter) Connected(sysId string, connected bool) {
-	gauge := r.connectedGauge.WithLabelValues(sysId)
+	gauge := r.connectedGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...)
 	if connected {
 		gauge.Set(1)
 	} else {
@@ -87,15 +104,15 @@ func (r *systemMetricsReporter) Connected(sysId string, connected bool) {
 }
 
 func (r *systemMetricsReporter) WaterTemperature(sysId string, temperature float64) {
-	r.waterTemperatureGauge.WithLabelValues(sysId).Set(temperature)
+	r.waterTemperatureGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...).Set(temperature)
 }
 
 func (r *systemMetricsReporter) ExternalTemperature(sysId string, temperature float64) {
-	r.externalTemperatureGauge.WithLabelValues(sysId).Set(temperature)
+	r.externalTemperatureGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...).Set(temperature)
 }
 
 func (r *systemMetricsReporter) Heating(sysId string, heating bool) {
-	gauge := r.heatingGauge.WithLabelValues(sysId)
+	gauge := r.heatingGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...)
 	if heating {
 		gauge.Set(1)
 	} else {
@@ -104,7 +121,7 @@ func (r *systemMetricsReporter) Heating(sysId string, heating bool) {
 }
 
 func (r *systemMetricsReporter) Eco(sysId string, eco bool) {
-	gauge := r.ecoGauge.WithLabelValues(sysId)
+	gauge := r.ecoGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...)
 	if eco {
 		gauge.Set(1)
 	} else {
@@ -113,17 +130,26 @@ func (r *systemMetricsReporter) Eco(sysId string, eco bool) {
 }
 
 func (r *systemMetricsReporter) RemoveDevice(sysId string) {
-	r.connectedGauge.DeleteLabelValues(sysId)
-	r.waterTemperatureGauge.DeleteLabelValues(sysId)
-	r.externalTemperatureGauge.DeleteLabelValues(sysId)
-	r.heatingGauge.DeleteLabelValues(sysId)
-	r.ecoGauge.DeleteLabelValues(sysId)
+	labels := prometheus.Labels{"sysId": sysId}
+	r.connectedGauge.DeletePartialMatch(labels)
+	r.waterTemperatureGauge.DeletePartialMatch(labels)
+	r.externalTemperatureGauge.DeletePartialMatch(labels)
+	r.heatingGauge.DeletePartialMatch(labels)
+	r.ecoGauge.DeletePartialMatch(labels)
 }
 
 // Room related required parameters
-var roomParameters = append(genericParameters, "id", "room")
+var roomParameters = append(genericParameters, "id")
+
+// Room info related required parameters
+var roomInfoParameters = append(genericParameters, "id", "room")
+
+// Room topology change related required parameters
+var roomChangeParameters = append(genericParameters, "change")
 
 type RoomMetricsReporter interface {
+	// Reports the room name.
+	RoomInfo(sysId string, id string, room string)
 	// Reports if the device connection is active or not.
 	RoomConnected(sysId string, id string, room string, connected bool)
 	// Reports the room temperature.
@@ -136,50 +162,84 @@ type RoomMetricsReporter interface {
 	RoomHumidity(sysId string, id string, room string, humidity float64)
 	// Reports the relay state.
 	RoomRelay(sysId string, id string, room string, connected bool)
+	// Reports a room topology change.
+	RoomChanged(sysId string, change string)
 	// Removes a room from reporting.
 	RemoveRoom(sysId string, id string, room string)
+	// Removes every room and the room topology changes of a device from reporting.
+	RemoveRooms(sysId string)
 }
 
 type roomMetricsReporter struct {
+	labels                     *customLabels
+	roomNameLabel              bool
+	roomInfoGauge              *prometheus.GaugeVec
 	roomConntectedGauge        *prometheus.GaugeVec
 	roomTemperatureGauge       *prometheus.GaugeVec
 	roomDewTemperatureGauge    *prometheus.GaugeVec
 	roomTargetTemperatureGauge *prometheus.GaugeVec
 	roomHumidityGauge          *prometheus.GaugeVec
 	roomRelayGauge             *prometheus.GaugeVec
+	roomChangesCounter         *prometheus.CounterVec
 }
 
-func newRoomMetricsReporter() RoomMetricsReporter {
+func newRoomMetricsReporter(factory promauto.Factory, labels *customLabels, roomNameLabel bool) RoomMetricsReporter {
+	parameters := labels.roomParameters(roomParameters...)
+	if roomNameLabel {
+		parameters = labels.roomParameters(roomInfoParameters...)
+	}
 	return &roomMetricsReporter{
-		roomConntectedGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		labels:        labels,
+		roomNameLabel: roomNameLabel,
+		roomInfoGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "icon_room_info",
+			Help: "For each room, reports 1 with the room name as label",
+		}, labels.roomParameters(roomInfoParameters...)),
+		roomConntectedGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_room_connected",
 			Help: "For each room, reports 1 if the room is connected to the controller, 0 otherwise",
-		}, roomParameters),
-		roomTemperatureGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		roomTemperatureGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_temperature",
 			Help: "For each room, reports the room temperature",
-		}, roomParameters),
-		roomDewTemperatureGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		roomDewTemperatureGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_dew_temperature",
 			Help: "For each room, reports the room dew temperature",
-		}, roomParameters),
-		roomTargetTemperatureGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		roomTargetTemperatureGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_target_temperature",
 			Help: "For each room, reports the target temperature",
-		}, roomParameters),
-		roomHumidityGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		roomHumidityGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_humidity",
 			Help: "For each room, reports the relative humidity",
-		}, roomParameters),
-		roomRelayGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		}, parameters),
+		roomRelayGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "icon_relay_on",
 			Help: "For each room, reports 1 if the relay is open, 0 otherwise",
-		}, roomParameters),
+		}, parameters),
+		roomChangesCounter: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "icon_room_changes_total",
+			Help: "For each controller, counts the added, removed and renamed rooms",
+		}, labels.deviceParameters(roomChangeParameters...)),
+	}
+}
+
+// Returns the label values of a room series.
+func (r *roomMetricsReporter) labelValues(sysId string, id string, room string) []string {
+	if r.roomNameLabel {
+		return r.labels.roomValues(sysId, id, sysId, id, room)
 	}
+	return r.labels.roomValues(sysId, id, sysId, id)
+}
+
+func (r *roomMetricsReporter) RoomInfo(sysId string, id string, room string) {
+	r.roomInfoGauge.WithLabelValues(r.labels.roomValues(sysId, id, sysId, id, room)...).Set(1)
 }
 
 func (r *roomMetricsReporter) RoomConnected(sysId string, id string, room string, connected bool) {
-	gauge := r.roomConntectedGauge.WithLabelValues(sysId, id, room)
+	gauge := r.roomConntectedGauge.WithLabelValues(r.labelValues(sysId, id, room)...)
 	if connected {
 		gauge.Set(1)
 	} else {
@@ -188,23 +248,23 @@ func (r *roomMetricsReporter) RoomConnected(sysId string, id string, room string
 }
 
 func (r *roomMetricsReporter) RoomTemperature(sysId string, id string, room string, temperature float64) {
-	r.roomTemperatureGauge.WithLabelValues(sysId, id, room).Set(temperature)
+	r.roomTemperatureGauge.WithLabelValues(r.labelValues(sysId, id, room)...).Set(temperature)
 }
 
 func (r *roomMetricsReporter) RoomDewTemperature(sysId string, id string, room string, temperature float64) {
-	r.roomDewTemperatureGauge.WithLabelValues(sysId, id, room).Set(temperature)
+	r.roomDewTemperatureGauge.WithLabelValues(r.labelValues(sysId, id, room)...).Set(temperature)
 }
 
 func (r *roomMetricsReporter) RoomTargetTemperature(sysId string, id string, room string, temperature float64) {
-	r.roomTargetTemperatureGauge.WithLabelValues(sysId, id, room).Set(temperature)
+	r.roomTargetTemperatureGauge.WithLabelValues(r.labelValues(sysId, id, room)...).Set(temperature)
 }
 
 func (r *roomMetricsReporter) RoomHumidity(sysId string, id string, room string, humidity float64) {
-	r.roomHumidityGauge.WithLabelValues(sysId, id, room).Set(humidity)
+	r.roomHumidityGauge.WithLabelValues(r.labelValues(sysId, id, room)...).Set(humidity)
 }
 
 func (r *roomMetricsReporter) RoomRelay(sysId string, id string, room string, connected bool) {
-	gauge := r.roomRelayGauge.WithLabelValues(sysId, id, room)
+	gauge := r.roomRelayGauge.WithLabelValues(r.labelValues(sysId, id, room)...)
 	if connected {
 		gauge.Set(1)
 	} else {
@@ -212,50 +272,231 @@ func (r *roomMetricsReporter) RoomRelay(sysId string, id string, room string, co
 	}
 }
 
+func (r *roomMetricsReporter) RoomChanged(sysId string, change string) {
+	r.roomChangesCounter.WithLabelValues(r.labels.deviceValues(sysId, sysId, change)...).Inc()
+}
+
 func (r *roomMetricsReporter) RemoveRoom(sysId string, id string, room string) {
-	r.roomConntectedGauge.DeleteLabelValues(sysId, id, room)
-	r.roomTemperatureGauge.DeleteLabelValues(sysId, id, room)
-	r.roomDewTemperatureGauge.DeleteLabelValues(sysId, id, room)
-	r.roomRelayGauge.DeleteLabelValues(sysId, id, room)
-	r.roomHumidityGauge.DeleteLabelValues(sysId, id, room)
-	r.roomTargetTemperatureGauge.DeleteLabelValues(sysId, id, room)
+	labels := prometheus.Labels{"sysId": sysId, "id": id}
+	r.roomInfoGauge.DeletePartialMatch(labels)
+	r.roomConntectedGauge.DeletePartialMatch(labels)
+	r.roomTemperatureGauge.DeletePartialMatch(labels)
+	r.roomDewTemperatureGauge.DeletePartialMatch(labels)
+	r.roomRelayGauge.DeletePartialMatch(labels)
+	r.roomHumidityGauge.DeletePartialMatch(labels)
+	r.roomTargetTemperatureGauge.DeletePartialMatch(labels)
+}
+
+func (r *roomMetricsReporter) RemoveRooms(sysId string) {
+	labels := prometheus.Labels{"sysId": sysId}
+	r.roomInfoGauge.DeletePartialMatch(labels)
+	r.roomConntectedGauge.DeletePartialMatch(labels)
+	r.roomTemperatureGauge.DeletePartialMatch(labels)
+	r.roomDewTemperatureGauge.DeletePartialMatch(labels)
+	r.roomRelayGauge.DeletePartialMatch(labels)
+	r.roomHumidityGauge.DeletePartialMatch(labels)
+	r.roomTargetTemperatureGauge.DeletePartialMatch(labels)
+	r.roomChangesCounter.DeletePartialMatch(labels)
 }
 
 // HTTP response related required parameters
-var httpParameters = append(genericParameters, "name", "response")
+var httpParameters = append(genericParameters, "name", "response", "error")
+
+// HTTP size related required parameters
+var httpSizeParameters = append(genericParameters, "name")
 
 type HttpMetricsReporter interface {
-	// Reports the HTTP response status code along with the duration.
-	HttpClientRequest(sysId string, name string, statusCode int, duration time.Duration)
+	// Reports the HTTP response status code and error along with the duration and sizes.
+	HttpClientRequest(sysId string, exchange *HttpExchange)
+	// Removes the HTTP metrics of a device from reporting.
+	RemoveHttp(sysId string)
 }
 
 type httpMetricsReporter struct {
-	httpSummary *prometheus.SummaryVec
+	labels                *customLabels
+	httpHistogram         *prometheus.HistogramVec
+	requestSizeHistogram  *prometheus.HistogramVec
+	responseSizeHistogram *prometheus.HistogramVec
 }
 
-func newHttpMetricsReporter() HttpMetricsReporter {
+func newHttpMetricsReporter(factory promauto.Factory, labels *customLabels, configuration *config.HttpClientConfiguration) HttpMetricsReporter {
+	nativeHistogramBucketFactor := float64(0)
+	if *configuration.NativeHistogram {
+		nativeHistogramBucketFactor = 1.1
+	}
+	sizeBuckets := prometheus.ExponentialBuckets(64, 4, 8)
 	return &httpMetricsReporter{
-		httpSummary: promauto.NewSummaryVec(prometheus.SummaryOpts{
-			Name: "icon_http_client_seconds",
-			Help: "iCon HTTP client requests",
-		}, httpParameters),
+		labels: labels,
+		httpHistogram: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:                        "icon_http_client_seconds",
+			Help:                        "iCon HTTP client requests",
+			Buckets:                     configuration.Buckets,
+			NativeHistogramBucketFactor: nativeHistogramBucketFactor,
+		}, labels.deviceParameters(httpParameters...)),
+		requestSizeHistogram: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:                        "icon_http_client_request_size_bytes",
+			Help:                        "iCon HTTP client request body sizes",
+			Buckets:                     sizeBuckets,
+			NativeHistogramBucketFactor: nativeHistogramBucketFactor,
+		}, labels.deviceParameters(httpSizeParameters...)),
+		responseSizeHistogram: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:                        "icon_http_client_response_size_bytes",
+			Help:                        "iCon HTTP client response body sizes",
+			Buckets:                     sizeBuckets,
+			NativeHistogramBucketFactor: nativeHistogramBucketFactor,
+		}, labels.deviceParameters(httpSizeParameters...)),
+	}
+}
+
+func (r *httpMetricsReporter) HttpClientRequest(sysId string, exchange *HttpExchange) {
+	response := ""
+	if exchange.StatusCode != 0 {
+		response = strconv.Itoa(exchange.StatusCode)
+	}
+	r.httpHistogram.WithLabelValues(r.labels.deviceValues(sysId, sysId, exchange.Name, response, exchange.Error)...).Observe(exchange.Duration().Seconds())
+	r.requestSizeHistogram.WithLabelValues(r.labels.deviceValues(sysId, sysId, exchange.Name)...).Observe(float64(exchange.RequestSize))
+	if exchange.StatusCode != 0 {
+		r.responseSizeHistogram.WithLabelValues(r.labels.deviceValues(sysId, sysId, exchange.Name)...).Observe(float64(exchange.ResponseSize))
+	}
+}
+
+func (r *httpMetricsReporter) RemoveHttp(sysId string) {
+	labels := prometheus.Labels{"sysId": sysId}
+	r.httpHistogram.DeletePartialMatch(labels)
+	r.requestSizeHistogram.DeletePartialMatch(labels)
+	r.responseSizeHistogram.DeletePartialMatch(labels)
+}
+
+// Poll health related required parameters
+var pollParameters = append(genericParameters, "stage", "result")
+
+type HealthMetricsReporter interface {
+	// Reports the current time as the last poll attempt.
+	LastAttempt(sysId string)
+	// Reports the current time as the last successful read.
+	LastSuccess(sysId string)
+	// Reports the number of failed polls since the last successful read.
+	ConsecutiveFailures(sysId string, failures int)
+	// Reports a poll stage result.
+	Poll(sysId string, stage string, success bool)
+	// Reports the duration of the last full poll cycle.
+	PollCycle(sysId string, duration time.Duration)
+	// Reports if the values are held from an earlier read.
+	Stale(sysId string, stale bool)
+	// Removes device health from reporting.
+	RemoveHealth(sysId string)
+}
+
+type healthMetricsReporter struct {
+	labels                   *customLabels
+	lastAttemptGauge         *prometheus.GaugeVec
+	lastSuccessGauge         *prometheus.GaugeVec
+	consecutiveFailuresGauge *prometheus.GaugeVec
+	pollsCounter             *prometheus.CounterVec
+	pollCycleGauge           *prometheus.GaugeVec
+	staleGauge               *prometheus.GaugeVec
+}
+
+func newHealthMetricsReporter(factory promauto.Factory, labels *customLabels) HealthMetricsReporter {
+	parameters := labels.deviceParameters(genericParameters...)
+	return &healthMetricsReporter{
+		labels: labels,
+		lastAttemptGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "icon_last_attempt_timestamp_seconds",
+			Help: "For each controller, reports the time of the last poll attempt since unix epoch in seconds",
+		}, parameters),
+		lastSuccessGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "icon_last_success_timestamp_seconds",
+			Help: "For each controller, reports the time of the last successful read since unix epoch in seconds",
+		}, parameters),
+		consecutiveFailuresGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "icon_consecutive_failures",
+			Help: "For each controller, reports the number of failed polls since the last successful read",
+		}, parameters),
+		pollsCounter: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "icon_polls_total",
+			Help: "For each controller, counts the successful and failed polls by stage",
+		}, labels.deviceParameters(pollParameters...)),
+		pollCycleGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "icon_poll_cycle_seconds",
+			Help: "For each controller, reports the duration of the last full poll cycle",
+		}, parameters),
+		staleGauge: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "icon_stale",
+			Help: "For each controller, reports 1 if the last read failed and the values are not fresh, 0 otherwise",
+		}, parameters),
+	}
+}
+
+func (r *healthMetricsReporter) LastAttempt(sysId string) {
+	r.lastAttemptGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...).SetToCurrentTime()
+}
+
+func (r *healthMetricsReporter) LastSuccess(sysId string) {
+	r.lastSuccessGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...).SetToCurrentTime()
+}
+
+func (r *healthMetricsReporter) ConsecutiveFailures(sysId string, failures int) {
+	r.consecutiveFailuresGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...).Set(float64(failures))
+}
+
+func (r *healthMetricsReporter) Poll(sysId string, stage string, success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	r.pollsCounter.WithLabelValues(r.labels.deviceValues(sysId, sysId, stage, result)...).Inc()
+}
+
+func (r *healthMetricsReporter) PollCycle(sysId string, duration time.Duration) {
+	r.pollCycleGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...).Set(duration.Seconds())
+}
+
+func (r *healthMetricsReporter) Stale(sysId string, stale bool) {
+	gauge := r.staleGauge.WithLabelValues(r.labels.deviceValues(sysId, sysId)...)
+	if stale {
+		gauge.Set(1)
+	} else {
+		gauge.Set(0)
 	}
 }
 
-func (r *httpMetricsReporter) HttpClientRequest(sysId string, name string, statusCode int, duration time.Duration) {
-	r.httpSummary.WithLabelValues(sysId, name, strconv.Itoa(statusCode)).Observe(duration.Seconds())
+func (r *healthMetricsReporter) RemoveHealth(sysId string) {
+	labels := prometheus.Labels{"sysId": sysId}
+	r.lastAttemptGauge.DeletePartialMatch(labels)
+	r.lastSuccessGauge.DeletePartialMatch(labels)
+	r.consecutiveFailuresGauge.DeletePartialMatch(labels)
+	r.pollsCounter.DeletePartialMatch(labels)
+	r.pollCycleGauge.DeletePartialMatch(labels)
+	r.staleGauge.DeletePartialMatch(labels)
 }
 
 type metricsReporter struct {
+	factory   promauto.Factory
+	startTime time.Time
+	labels    *customLabels
 	HttpMetricsReporter
 	RoomMetricsReporter
 	SystemMetricsReporter
+	HealthMetricsReporter
 }
 
-func NewPrometheusReporter() MetricsReporter {
+// Creates a new reporter, which registers the metrics to the registry.
+func NewPrometheusReporter(registry *prometheus.Registry, configuration *config.Configuration) MetricsReporter {
+	factory := promauto.With(registry)
+	labels := newCustomLabels(configuration.DeviceLabelNames(), configuration.RoomLabelNames())
 	return &metricsReporter{
-		SystemMetricsReporter: newSystemPrometheusReporter(),
-		RoomMetricsReporter:   newRoomMetricsReporter(),
-		HttpMetricsReporter:   newHttpMetricsReporter(),
+		factory:               factory,
+		startTime:             time.Now(),
+		labels:                labels,
+		SystemMetricsReporter: newSystemPrometheusReporter(factory, labels),
+		RoomMetricsReporter:   newRoomMetricsReporter(factory, labels, *configuration.Compatibility.RoomNameLabel),
+		HttpMetricsReporter:   newHttpMetricsReporter(factory, labels, configuration.HttpClient),
+		HealthMetricsReporter: newHealthMetricsReporter(factory, labels),
 	}
 }
+
+// Sets the custom labels of a device and its rooms.
+func (r *metricsReporter) SetLabels(sysId string, labels map[string]string, roomLabels map[string]map[string]string) {
+	r.labels.set(sysId, labels, roomLabels)
+}
@@ -2,12 +2,15 @@ package metrics
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -18,26 +21,37 @@ type PrometheusPublisher interface {
 	Start() error
 	// Stops serving and listening.
 	Stop(context context.Context) error
+	// Registers an additional handler for the pattern.
+	Handle(pattern string, handler http.Handler)
 }
 
 // HTTP server
 type prometheusPublisher struct {
 	server *http.Server
+	mux    *http.ServeMux
+	// Either tcp or unix.
+	network string
+	web     *config.WebConfiguration
 }
 
-// Creates a new server with the given port
-func NewPrometheusPublisher(port int) PrometheusPublisher {
-	publisher := &prometheusPublisher{}
-	promhttpHandler := promhttp.Handler()
+// Creates a new server with the configured address, which publishes the metrics of the gatherer.
+func NewPrometheusPublisher(configuration *config.Configuration, registry *prometheus.Registry, gatherer prometheus.Gatherer) PrometheusPublisher {
+	publisher := &prometheusPublisher{web: configuration.Web}
+	promhttpHandler := promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		Registry: registry,
+	}))
 	mux := http.NewServeMux()
 	mux.Handle("GET /metrics", promhttpHandler)
 	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
+	publisher.mux = mux
+	network, address := configuration.ListenAddress()
+	publisher.network = network
 	publisher.server = &http.Server{
-		Addr:           fmt.Sprintf(":%d", port),
-		Handler:        mux,
+		Addr:           address,
+		Handler:        newAuthHandler(configuration.Web, mux),
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
@@ -45,18 +59,42 @@ func NewPrometheusPublisher(port int) PrometheusPublisher {
 	return publisher
 }
 
+// Registers an additional handler for the pattern.
+func (publisher *prometheusPublisher) Handle(pattern string, handler http.Handler) {
+	publisher.mux.Handle(pattern, handler)
+}
+
 // Starts to listen and serve.
 func (publisher *prometheusPublisher) Start() error {
-	ln, err := net.Listen("tcp", publisher.server.Addr)
+	tlsConfig, err := newTlsConfig(publisher.web)
+	if err != nil {
+		return err
+	}
+	ln, err := Listen(publisher.network, publisher.server.Addr)
 	if err != nil {
 		return err
 	}
+	if tlsConfig != nil {
+		publisher.server.TLSConfig = tlsConfig
+		ln = tls.NewListener(ln, tlsConfig)
+	}
 	go func() {
 		publisher.server.Serve(ln)
 	}()
 	return nil
 }
 
+// Listens on the address, the unix socket left behind by a previous run is removed.
+func Listen(network string, address string) (net.Listener, error) {
+	if network == "unix" {
+		info, err := os.Stat(address)
+		if err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	return net.Listen(network, address)
+}
+
 // Stops serving and listening.
 func (publisher *prometheusPublisher) Stop(context context.Context) error {
 	return publisher.server.Shutdown(context)
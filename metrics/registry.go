@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Creates a new registry with the enabled standard collectors.
+func NewRegistry(configuration *config.CollectorsConfiguration) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	if *configuration.Go {
+		registry.MustRegister(collectors.NewGoCollector())
+	}
+	if *configuration.Process {
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	if *configuration.BuildInfo {
+		registry.MustRegister(collectors.NewBuildInfoCollector())
+	}
+	return registry
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reports the configuration reloads.
+type ReloadReporter interface {
+	// Reports the result of a configuration reload.
+	Reloaded(success bool)
+}
+
+type reloadReporter struct {
+	reloadsCounter      *prometheus.CounterVec
+	lastSuccessfulGauge prometheus.Gauge
+	lastSuccessGauge    prometheus.Gauge
+}
+
+// Creates a new reporter, which registers the reload metrics to the registry.
+// The initial configuration load is reported as successful.
+func NewReloadReporter(registry *prometheus.Registry) ReloadReporter {
+	factory := promauto.With(registry)
+	r := &reloadReporter{
+		reloadsCounter: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "icon_config_reloads_total",
+			Help: "Counts the successful and failed configuration reloads",
+		}, []string{"result"}),
+		lastSuccessfulGauge: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "icon_config_last_reload_successful",
+			Help: "Reports 1 if the last configuration reload was successful, 0 otherwise",
+		}),
+		lastSuccessGauge: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "icon_config_last_reload_success_timestamp_seconds",
+			Help: "Reports the time of the last successful configuration reload since unix epoch in seconds",
+		}),
+	}
+	r.reloadsCounter.WithLabelValues("success")
+	r.reloadsCounter.WithLabelValues("failure")
+	r.lastSuccessfulGauge.Set(1)
+	r.lastSuccessGauge.SetToCurrentTime()
+	return r
+}
+
+func (r *reloadReporter) Reloaded(success bool) {
+	if success {
+		r.reloadsCounter.WithLabelValues("success").Inc()
+		r.lastSuccessfulGauge.Set(1)
+		r.lastSuccessGauge.SetToCurrentTime()
+	} else {
+		r.reloadsCounter.WithLabelValues("failure").Inc()
+		r.lastSuccessfulGauge.Set(0)
+	}
+}
@@ -1,9 +1,12 @@
 package metrics
 
 import (
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/logging"
 	"github.com/csutorasa/icon-metrics/model"
 )
 
@@ -14,9 +17,31 @@ type MetricsSession interface {
 	// Reports metrics based on device data.
 	Report(values *model.DataPollResponse)
 	// Reports HTTP metrics.
-	HttpClientRequest(endpointName string, statusCode int, duration time.Duration)
+	HttpClientRequest(exchange *HttpExchange)
+	// Reports a successful poll stage.
+	PollSucceeded(stage string)
+	// Reports a failed poll stage and removes the held values when the hold policy expires.
+	PollFailed(stage string)
+	// Reports the duration of a full poll cycle.
+	PollCycle(duration time.Duration)
 	// Resets all metrics.
 	Reset()
+	// Applies a changed device configuration and resets all metrics.
+	Configure(configuration *config.IconConfiguration)
+	// Removes every metric of the device, the session is not used afterwards.
+	Close()
+	// Returns the current health state, safe to call from any goroutine.
+	State() SessionState
+}
+
+// Health state of the session.
+type SessionState struct {
+	// Debounced connection state.
+	Connected bool
+	// Time of the last successful read, zero if there was none.
+	LastSuccess time.Time
+	// Failed polls since the last successful read.
+	ConsecutiveFailures int
 }
 
 // Room data holder.
@@ -25,92 +50,243 @@ type roomDescriptor struct {
 	Name string
 }
 
+// Poll stages.
+const (
+	StageLogin = "login"
+	StageRead  = "read"
+)
+
+// Room topology change types.
+const (
+	RoomAdded   = "added"
+	RoomRemoved = "removed"
+	RoomRenamed = "renamed"
+)
+
 // Metrics session data holder.
 type metricsSession struct {
-	sysId               string
-	roomDescriptors     []roomDescriptor
-	reportConfiguration *config.ReportConfiguration
-	reporter            MetricsReporter
+	sysId string
+	// Known rooms by id, nil until the first report.
+	roomDescriptors map[string]roomDescriptor
+	configuration   *config.IconConfiguration
+	reporter        MetricsReporter
+	logger          *slog.Logger
+	// Guards the health state against concurrent State calls.
+	lock sync.Mutex
+	// Failed polls since the last successful read.
+	consecutiveFailures int
+	// Time of the last successful read.
+	lastSuccess time.Time
+	// Debounced connection state.
+	connected bool
+	// Time of the first failure since the last connection, zero if connected.
+	disconnectedSince time.Time
 }
 
 // Creates a new session to report metrics.
-func NewSession(sysId string, reportConfiguration *config.ReportConfiguration, reporter MetricsReporter) MetricsSession {
+func NewSession(configuration *config.IconConfiguration, reporter MetricsReporter) MetricsSession {
+	reporter.SetLabels(configuration.SysId, configuration.Labels, configuration.RoomLabels)
 	return &metricsSession{
-		sysId:               sysId,
-		roomDescriptors:     make([]roomDescriptor, 0),
-		reportConfiguration: reportConfiguration,
-		reporter:            reporter,
+		sysId:           configuration.SysId,
+		roomDescriptors: nil,
+		configuration:   configuration,
+		reporter:        reporter,
+		logger:          logging.Device(configuration.SysId, configuration.Url),
 	}
 }
 
-// Reports connected metric.
+// Reports connected metric, disconnection is reported after the debounce duration.
 func (session *metricsSession) Connected(connected bool) {
-	if *session.reportConfiguration.ControllerConnected {
+	if connected {
+		session.disconnectedSince = time.Time{}
+		session.setConnected(true)
+		return
+	}
+	if session.disconnectedSince.IsZero() {
+		session.disconnectedSince = time.Now()
+	}
+	debounce := time.Duration(*session.configuration.Hold.ConnectedDebounce) * time.Second
+	if !session.connected || time.Since(session.disconnectedSince) >= debounce {
+		session.setConnected(false)
+	}
+}
+
+// Updates and reports the debounced connection state.
+func (session *metricsSession) setConnected(connected bool) {
+	session.lock.Lock()
+	session.connected = connected
+	session.lock.Unlock()
+	if *session.configuration.Report.ControllerConnected {
 		session.reporter.Connected(session.sysId, connected)
 	}
 }
 
 // Reports metrics based on device data.
 func (session *metricsSession) Report(values *model.DataPollResponse) {
-	if len(session.roomDescriptors) == 0 {
-		for id, thermostat := range values.Thermostats {
-			if thermostat.Enabled == 0 {
-				continue
-			}
-			session.roomDescriptors = append(session.roomDescriptors, roomDescriptor{Id: id, Name: thermostat.Name})
-		}
-	}
+	session.reconcileRooms(values.Thermostats)
 
-	if *session.reportConfiguration.ExternalTemperature {
+	if *session.configuration.Report.ExternalTemperature {
 		session.reporter.ExternalTemperature(session.sysId, values.ExternalTemperature)
 	}
-	if *session.reportConfiguration.WaterTemperature {
+	if *session.configuration.Report.WaterTemperature {
 		session.reporter.WaterTemperature(session.sysId, values.WaterTemperature)
 	}
-	if *session.reportConfiguration.Heating {
+	if *session.configuration.Report.Heating {
 		session.reporter.Heating(session.sysId, values.HeatingCooling == model.Heating)
 	}
-	if *session.reportConfiguration.Eco {
+	if *session.configuration.Report.Eco {
 		session.reporter.Eco(session.sysId, values.ComfortEco == model.Eco)
 	}
 	for id, thermostat := range values.Thermostats {
 		if thermostat.Enabled == 0 {
 			continue
 		}
+		report := session.configuration.RoomReport(id, thermostat.Name)
+		if report == nil {
+			session.reporter.RemoveRoom(session.sysId, id, thermostat.Name)
+			continue
+		}
 		if thermostat.Live == 0 {
 			session.reporter.RemoveRoom(session.sysId, id, thermostat.Name)
 			session.reporter.RoomConnected(session.sysId, id, thermostat.Name, false)
+			if *report.RoomInfo {
+				session.reporter.RoomInfo(session.sysId, id, thermostat.Name)
+			}
 			continue
 		}
-		if *session.reportConfiguration.RoomConnected {
+		if *report.RoomInfo {
+			session.reporter.RoomInfo(session.sysId, id, thermostat.Name)
+		}
+		if *report.RoomConnected {
 			session.reporter.RoomConnected(session.sysId, id, thermostat.Name, true)
 		}
-		if *session.reportConfiguration.Temperature {
+		if *report.Temperature {
 			session.reporter.RoomTemperature(session.sysId, id, thermostat.Name, thermostat.Temperature)
 		}
-		if *session.reportConfiguration.DewTemperature {
+		if *report.DewTemperature {
 			session.reporter.RoomDewTemperature(session.sysId, id, thermostat.Name, thermostat.DewTemperature)
 		}
-		if *session.reportConfiguration.Relay {
+		if *report.Relay {
 			relay := false
 			if thermostat.Relay > 0 {
 				relay = true
 			}
 			session.reporter.RoomRelay(session.sysId, id, thermostat.Name, relay)
 		}
-		if *session.reportConfiguration.Humidity {
+		if *report.Humidity {
 			session.reporter.RoomHumidity(session.sysId, id, thermostat.Name, thermostat.RelativeHumidity)
 		}
-		if *session.reportConfiguration.TargetTemperature {
+		if *report.TargetTemperature {
 			session.reporter.RoomTargetTemperature(session.sysId, id, thermostat.Name, thermostat.TargetTemperature())
 		}
 	}
 }
 
+// Detects added, removed and renamed rooms and removes the stale metrics.
+func (session *metricsSession) reconcileRooms(thermostats map[string]*model.DP) {
+	current := make(map[string]roomDescriptor)
+	for id, thermostat := range thermostats {
+		if thermostat.Enabled == 0 {
+			continue
+		}
+		current[id] = roomDescriptor{Id: id, Name: thermostat.Name}
+	}
+	if session.roomDescriptors == nil {
+		session.logger.Info("Discovered rooms", logging.Operation("rooms"), slog.Int("rooms", len(current)))
+		session.roomDescriptors = current
+		return
+	}
+	for id, previous := range session.roomDescriptors {
+		room, ok := current[id]
+		if !ok {
+			session.logger.Info("Room is removed", logging.Operation("rooms"), slog.String("id", previous.Id), slog.String("room", previous.Name))
+			session.reporter.RemoveRoom(session.sysId, previous.Id, previous.Name)
+			session.roomChanged(RoomRemoved)
+		} else if room.Name != previous.Name {
+			session.logger.Info("Room is renamed", logging.Operation("rooms"), slog.String("id", room.Id), slog.String("previous", previous.Name), slog.String("room", room.Name))
+			session.reporter.RemoveRoom(session.sysId, previous.Id, previous.Name)
+			session.roomChanged(RoomRenamed)
+		}
+	}
+	for id, room := range current {
+		if _, ok := session.roomDescriptors[id]; !ok {
+			session.logger.Info("Room is added", logging.Operation("rooms"), slog.String("id", room.Id), slog.String("room", room.Name))
+			session.roomChanged(RoomAdded)
+		}
+	}
+	session.roomDescriptors = current
+}
+
+// Reports room topology change metrics.
+func (session *metricsSession) roomChanged(change string) {
+	if *session.configuration.Report.RoomChanges {
+		session.reporter.RoomChanged(session.sysId, change)
+	}
+}
+
 // Reports HTTP metrics.
-func (session *metricsSession) HttpClientRequest(endpointName string, statusCode int, duration time.Duration) {
-	if *session.reportConfiguration.HttpClient {
-		session.reporter.HttpClientRequest(session.sysId, endpointName, statusCode, duration)
+func (session *metricsSession) HttpClientRequest(exchange *HttpExchange) {
+	if *session.configuration.Report.HttpClient {
+		session.reporter.HttpClientRequest(session.sysId, exchange)
+	}
+}
+
+// Reports a successful poll stage.
+func (session *metricsSession) PollSucceeded(stage string) {
+	if stage == StageRead {
+		session.lock.Lock()
+		session.consecutiveFailures = 0
+		session.lastSuccess = time.Now()
+		session.lock.Unlock()
+	}
+	if *session.configuration.Report.Health {
+		session.reporter.LastAttempt(session.sysId)
+		session.reporter.Poll(session.sysId, stage, true)
+		if stage == StageRead {
+			session.reporter.LastSuccess(session.sysId)
+			session.reporter.ConsecutiveFailures(session.sysId, session.consecutiveFailures)
+			session.reporter.Stale(session.sysId, false)
+		}
+	}
+}
+
+// Reports a failed poll stage.
+func (session *metricsSession) PollFailed(stage string) {
+	session.lock.Lock()
+	session.consecutiveFailures++
+	session.lock.Unlock()
+	if *session.configuration.Report.Health {
+		session.reporter.LastAttempt(session.sysId)
+		session.reporter.Poll(session.sysId, stage, false)
+		session.reporter.ConsecutiveFailures(session.sysId, session.consecutiveFailures)
+		session.reporter.Stale(session.sysId, true)
+	}
+	if session.holdExpired() {
+		session.Reset()
+	}
+	session.Connected(false)
+}
+
+// Returns if the held values should be removed.
+func (session *metricsSession) holdExpired() bool {
+	hold := session.configuration.Hold
+	failures, duration := *hold.Failures, *hold.Duration
+	if failures == 0 && duration == 0 {
+		return true
+	}
+	if failures > 0 && session.consecutiveFailures > failures {
+		return true
+	}
+	if duration > 0 && time.Since(session.lastSuccess) > time.Duration(duration)*time.Second {
+		return true
+	}
+	return false
+}
+
+// Reports the duration of a full poll cycle.
+func (session *metricsSession) PollCycle(duration time.Duration) {
+	if *session.configuration.Report.Health {
+		session.reporter.PollCycle(session.sysId, duration)
 	}
 }
 
@@ -120,6 +296,35 @@ func (session *metricsSession) Reset() {
 		session.reporter.RemoveRoom(session.sysId, roomDescriptor.Id, roomDescriptor.Name)
 	}
 	session.reporter.RemoveDevice(session.sysId)
-	session.reporter.Connected(session.sysId, false)
-	session.roomDescriptors = make([]roomDescriptor, 0)
+	if *session.configuration.Report.ControllerConnected {
+		session.reporter.Connected(session.sysId, session.connected)
+	}
+}
+
+// Applies a changed device configuration and resets all metrics.
+func (session *metricsSession) Configure(configuration *config.IconConfiguration) {
+	session.configuration = configuration
+	session.Reset()
+	if !*configuration.Report.Health {
+		session.reporter.RemoveHealth(session.sysId)
+	}
+}
+
+// Removes every metric of the device, the session is not used afterwards.
+func (session *metricsSession) Close() {
+	session.reporter.RemoveDevice(session.sysId)
+	session.reporter.RemoveRooms(session.sysId)
+	session.reporter.RemoveHttp(session.sysId)
+	session.reporter.RemoveHealth(session.sysId)
+}
+
+// Returns the current health state, safe to call from any goroutine.
+func (session *metricsSession) State() SessionState {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return SessionState{
+		Connected:           session.connected,
+		LastSuccess:         session.lastSuccess,
+		ConsecutiveFailures: session.consecutiveFailures,
+	}
 }
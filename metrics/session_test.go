@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/model"
+)
+
+func TestHoldExpired(t *testing.T) {
+	tests := []struct {
+		name                string
+		failures            int
+		duration            int
+		consecutiveFailures int
+		sinceLastSuccess    time.Duration
+		expected            bool
+	}{
+		{name: "no hold expires immediately", consecutiveFailures: 1, expected: true},
+		{name: "failures within the limit are held", failures: 3, consecutiveFailures: 3, expected: false},
+		{name: "failures over the limit expire", failures: 3, consecutiveFailures: 4, expected: true},
+		{name: "failure limit ignores the duration", failures: 3, consecutiveFailures: 1, sinceLastSuccess: time.Hour, expected: false},
+		{name: "duration within the limit is held", duration: 60, consecutiveFailures: 10, sinceLastSuccess: 30 * time.Second, expected: false},
+		{name: "duration over the limit expires", duration: 60, consecutiveFailures: 1, sinceLastSuccess: 2 * time.Minute, expected: true},
+		{name: "both limits are held", failures: 3, duration: 60, consecutiveFailures: 2, sinceLastSuccess: 30 * time.Second, expected: false},
+		{name: "failures expire before the duration", failures: 3, duration: 60, consecutiveFailures: 4, sinceLastSuccess: 30 * time.Second, expected: true},
+		{name: "duration expires before the failures", failures: 3, duration: 60, consecutiveFailures: 2, sinceLastSuccess: 2 * time.Minute, expected: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := &metricsSession{
+				configuration: &config.IconConfiguration{
+					Hold: &config.HoldConfiguration{Failures: &test.failures, Duration: &test.duration},
+				},
+				consecutiveFailures: test.consecutiveFailures,
+				lastSuccess:         time.Now().Add(-test.sinceLastSuccess),
+			}
+			if actual := session.holdExpired(); actual != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, actual)
+			}
+		})
+	}
+}
+
+// Records the room removals and changes of the session.
+type roomRecorder struct {
+	MetricsReporter
+	removed []string
+	changes []string
+}
+
+func (r *roomRecorder) RemoveRoom(sysId string, id string, room string) {
+	r.removed = append(r.removed, id+"/"+room)
+}
+
+func (r *roomRecorder) RoomChanged(sysId string, change string) {
+	r.changes = append(r.changes, change)
+}
+
+func TestReconcileRooms(t *testing.T) {
+	reportChanges := true
+	recorder := &roomRecorder{}
+	session := &metricsSession{
+		sysId:         "123456789012",
+		configuration: &config.IconConfiguration{Report: &config.ReportConfiguration{RoomChanges: &reportChanges}},
+		reporter:      recorder,
+		logger:        slog.New(slog.DiscardHandler),
+	}
+
+	session.reconcileRooms(map[string]*model.DP{
+		"1": {Enabled: 1, Name: "Living room"},
+		"2": {Enabled: 1, Name: "Bedroom"},
+		"3": {Enabled: 0, Name: "Unused"},
+	})
+	if len(recorder.removed) != 0 || len(recorder.changes) != 0 {
+		t.Fatalf("first read is not a change, removed %v, changes %v", recorder.removed, recorder.changes)
+	}
+
+	session.reconcileRooms(map[string]*model.DP{
+		"1": {Enabled: 1, Name: "Lounge"},
+		"3": {Enabled: 1, Name: "Office"},
+	})
+	slices.Sort(recorder.removed)
+	slices.Sort(recorder.changes)
+	if !slices.Equal(recorder.removed, []string{"1/Living room", "2/Bedroom"}) {
+		t.Errorf("renamed and removed rooms are not removed: %v", recorder.removed)
+	}
+	if !slices.Equal(recorder.changes, []string{RoomAdded, RoomRemoved, RoomRenamed}) {
+		t.Errorf("unexpected changes %v", recorder.changes)
+	}
+
+	recorder.removed, recorder.changes = nil, nil
+	reportChanges = false
+	session.reconcileRooms(map[string]*model.DP{
+		"1": {Enabled: 0, Name: "Lounge"},
+		"3": {Enabled: 1, Name: "Office"},
+	})
+	if !slices.Equal(recorder.removed, []string{"1/Lounge"}) {
+		t.Errorf("disabled room is not removed: %v", recorder.removed)
+	}
+	if len(recorder.changes) != 0 {
+		t.Errorf("changes are reported while disabled: %v", recorder.changes)
+	}
+}
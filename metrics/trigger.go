@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Maximum duration a scrape waits for the device refreshes, below the write timeout of the http server.
+const refreshTimeout = 5 * time.Second
+
+// Gatherer, which refreshes the registered devices before gathering the metrics.
+type ScrapeTrigger interface {
+	prometheus.Gatherer
+	// Registers a refresh function to be called on every scrape.
+	Register(sysId string, refresh func())
+	// Removes a refresh function.
+	Unregister(sysId string)
+}
+
+// Gatherer, which refreshes the registered devices before gathering the metrics.
+type scrapeTrigger struct {
+	gatherer prometheus.Gatherer
+	// Maximum duration the scrape waits for the refreshes.
+	timeout    time.Duration
+	lock       sync.RWMutex
+	refreshers map[string]func()
+}
+
+// Creates a new trigger, which gathers the metrics from the gatherer.
+func NewScrapeTrigger(gatherer prometheus.Gatherer) ScrapeTrigger {
+	return &scrapeTrigger{
+		gatherer:   gatherer,
+		timeout:    refreshTimeout,
+		refreshers: make(map[string]func()),
+	}
+}
+
+// Registers a refresh function to be called on every scrape.
+func (trigger *scrapeTrigger) Register(sysId string, refresh func()) {
+	trigger.lock.Lock()
+	defer trigger.lock.Unlock()
+	trigger.refreshers[sysId] = refresh
+}
+
+// Removes a refresh function.
+func (trigger *scrapeTrigger) Unregister(sysId string) {
+	trigger.lock.Lock()
+	defer trigger.lock.Unlock()
+	delete(trigger.refreshers, sysId)
+}
+
+// Refreshes all registered devices in parallel, then gathers the metrics.
+// The devices, which are not refreshed before the timeout, are gathered with their last values.
+func (trigger *scrapeTrigger) Gather() ([]*dto.MetricFamily, error) {
+	trigger.lock.RLock()
+	var wg sync.WaitGroup
+	for _, refresh := range trigger.refreshers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			refresh()
+		}()
+	}
+	trigger.lock.RUnlock()
+	refreshed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(refreshed)
+	}()
+	timer := time.NewTimer(trigger.timeout)
+	defer timer.Stop()
+	select {
+	case <-refreshed:
+	case <-timer.C:
+		// The slow refreshes are finished in the background, the next scrape is served with their values.
+	}
+	return trigger.gatherer.Gather()
+}
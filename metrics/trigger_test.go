@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Returns the value of the gathered gauge.
+func gatheredGauge(t *testing.T, trigger ScrapeTrigger) float64 {
+	t.Helper()
+	families, err := trigger.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 {
+		t.Fatalf("expected 1 metric family, got %d", len(families))
+	}
+	return families[0].GetMetric()[0].GetGauge().GetValue()
+}
+
+func TestScrapeTriggerRefreshesBeforeGather(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_value"})
+	registry.MustRegister(gauge)
+	trigger := NewScrapeTrigger(registry)
+
+	var calls atomic.Int32
+	trigger.Register("123456789012", func() {
+		calls.Add(1)
+		gauge.Set(1)
+	})
+	trigger.Register("210987654321", func() {
+		calls.Add(1)
+	})
+	if value := gatheredGauge(t, trigger); value != 1 {
+		t.Errorf("refreshed value is not gathered, got %v", value)
+	}
+
+	trigger.Unregister("123456789012")
+	gauge.Set(2)
+	if value := gatheredGauge(t, trigger); value != 2 {
+		t.Errorf("unregistered refresh is called, got %v", value)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 refresh calls, got %d", calls.Load())
+	}
+}
+
+func TestScrapeTriggerTimeout(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_value"})
+	registry.MustRegister(gauge)
+	trigger := &scrapeTrigger{gatherer: registry, timeout: 50 * time.Millisecond, refreshers: make(map[string]func())}
+
+	release := make(chan struct{})
+	refreshed := make(chan struct{})
+	trigger.Register("123456789012", func() {
+		<-release
+		gauge.Set(1)
+		close(refreshed)
+	})
+	start := time.Now()
+	if value := gatheredGauge(t, trigger); value != 0 {
+		t.Errorf("slow refresh is gathered, got %v", value)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gather is not bounded by the timeout, took %v", elapsed)
+	}
+
+	close(release)
+	<-refreshed
+	trigger.Unregister("123456789012")
+	if value := gatheredGauge(t, trigger); value != 1 {
+		t.Errorf("slow refresh is not finished in the background, got %v", value)
+	}
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Client certificate policies by name.
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// TLS versions by name.
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// Paths served without authentication, so the health checks do not need credentials or client certificate.
+var publicPaths = []string{"/status", "/healthz", "/readyz"}
+
+// Hash compared for unknown users, so they take as long as the known ones.
+var unknownUserHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("unknown"), bcrypt.DefaultCost)
+	return hash
+})
+
+// Creates the TLS configuration with the certificates loaded, nil if TLS is not configured.
+func newTlsConfig(web *config.WebConfiguration) (*tls.Config, error) {
+	if web == nil || web.TlsServer == nil {
+		return nil, nil
+	}
+	configuration := web.TlsServer
+	certificate, err := tls.LoadX509KeyPair(configuration.CertFile, configuration.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   handshakeClientAuth(tlsClientAuthTypes[configuration.ClientAuthType]),
+		MinVersion:   tlsVersions[configuration.MinVersion],
+		MaxVersion:   tlsVersions[configuration.MaxVersion],
+	}
+	if configuration.ClientCaFile != "" {
+		data, err := os.ReadFile(configuration.ClientCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("failed to parse client CA %s", configuration.ClientCaFile)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// Returns the client certificate policy of the TLS handshake.
+// The required client certificates are checked by the http handler instead, so the public paths can be reached without one.
+func handshakeClientAuth(clientAuth tls.ClientAuthType) tls.ClientAuthType {
+	switch clientAuth {
+	case tls.RequireAnyClientCert:
+		return tls.RequestClientCert
+	case tls.RequireAndVerifyClientCert:
+		return tls.VerifyClientCertIfGiven
+	}
+	return clientAuth
+}
+
+// Requires the client certificate and the basic authentication for the handler, except for the public paths.
+type authHandler struct {
+	handler http.Handler
+	// Client certificate policy, which is not enforced by the TLS handshake.
+	clientAuth tls.ClientAuthType
+	// Password bcrypt hashes by user name.
+	users map[string]string
+	lock  sync.Mutex
+	// Successfully authenticated credentials, so bcrypt is not run on every scrape.
+	authenticated map[[sha256.Size]byte]bool
+}
+
+// Wraps the handler with authentication, the handler is returned if there are no users and the client certificate is not required.
+func newAuthHandler(web *config.WebConfiguration, handler http.Handler) http.Handler {
+	if web == nil {
+		return handler
+	}
+	clientAuth := tls.NoClientCert
+	if web.TlsServer != nil {
+		clientAuth = tlsClientAuthTypes[web.TlsServer.ClientAuthType]
+	}
+	if len(web.BasicAuthUsers) == 0 && clientAuth != tls.RequireAnyClientCert && clientAuth != tls.RequireAndVerifyClientCert {
+		return handler
+	}
+	return &authHandler{
+		handler:       handler,
+		clientAuth:    clientAuth,
+		users:         web.BasicAuthUsers,
+		authenticated: make(map[[sha256.Size]byte]bool),
+	}
+}
+
+// Serves the request if the client certificate and the credentials are valid.
+func (handler *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if slices.Contains(publicPaths, r.URL.Path) {
+		handler.handler.ServeHTTP(w, r)
+		return
+	}
+	if !handler.hasClientCertificate(r) {
+		http.Error(w, "client certificate is required", http.StatusForbidden)
+		return
+	}
+	if len(handler.users) != 0 {
+		user, password, ok := r.BasicAuth()
+		if !ok || !handler.authenticate(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="icon-metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+	handler.handler.ServeHTTP(w, r)
+}
+
+// Returns if the request has the client certificate required by the policy.
+func (handler *authHandler) hasClientCertificate(r *http.Request) bool {
+	switch handler.clientAuth {
+	case tls.RequireAnyClientCert:
+		return r.TLS != nil && len(r.TLS.PeerCertificates) != 0
+	case tls.RequireAndVerifyClientCert:
+		return r.TLS != nil && len(r.TLS.VerifiedChains) != 0
+	}
+	return true
+}
+
+// Returns if the password of the user is valid.
+func (handler *authHandler) authenticate(user string, password string) bool {
+	hash, known := handler.users[user]
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	handler.lock.Lock()
+	cached := handler.authenticated[key]
+	handler.lock.Unlock()
+	if cached {
+		return true
+	}
+	if !known {
+		bcrypt.CompareHashAndPassword(unknownUserHash(), []byte(password))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	handler.lock.Lock()
+	handler.authenticated[key] = true
+	handler.lock.Unlock()
+	return true
+}
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Test certificate with its key.
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// Creates a certificate signed by the parent, or a self-signed CA if the parent is nil.
+func newTestCertificate(t *testing.T, name string, parent *testCertificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{certificate: certificate, key: key}
+}
+
+// Returns the certificate for the TLS client or server.
+func (c *testCertificate) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.certificate.Raw}, PrivateKey: c.key}
+}
+
+// Writes the certificate and the key as PEM files, returns their paths.
+func (c *testCertificate) write(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, c.certificate.Subject.CommonName+".crt")
+	keyFile := filepath.Join(dir, c.certificate.Subject.CommonName+".key")
+	key, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.certificate.Raw}), 0600)
+	if err == nil {
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestAuthHandlerBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newAuthHandler(&config.WebConfiguration{BasicAuthUsers: map[string]string{"prometheus": string(hash)}}, http.NotFoundHandler())
+
+	for path, expected := range map[string]int{
+		"/metrics": http.StatusUnauthorized,
+		"/probe":   http.StatusUnauthorized,
+		"/healthz": http.StatusNotFound,
+		"/readyz":  http.StatusNotFound,
+		"/status":  http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != expected {
+			t.Errorf("expected %d for %s without credentials, got %d", expected, path, recorder.Code)
+		}
+	}
+
+	for _, credentials := range [][2]string{{"prometheus", "secret"}, {"prometheus", "secret"}, {"prometheus", "wrong"}, {"unknown", "secret"}} {
+		request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		request.SetBasicAuth(credentials[0], credentials[1])
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		expected := http.StatusUnauthorized
+		if credentials[1] == "secret" && credentials[0] == "prometheus" {
+			expected = http.StatusNotFound
+		}
+		if recorder.Code != expected {
+			t.Errorf("expected %d for %s:%s, got %d", expected, credentials[0], credentials[1], recorder.Code)
+		}
+	}
+}
+
+func TestAuthHandlerWithoutUsersAndClientCertificate(t *testing.T) {
+	handler := http.NotFoundHandler()
+	web := &config.WebConfiguration{TlsServer: &config.TlsServerConfiguration{ClientAuthType: "VerifyClientCertIfGiven"}}
+	if _, ok := newAuthHandler(web, handler).(*authHandler); ok {
+		t.Error("handler is wrapped without users and required client certificate")
+	}
+}
+
+func TestClientCertificateRequired(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	server := newTestCertificate(t, "server", ca)
+	client := newTestCertificate(t, "client", ca)
+	rogue := newTestCertificate(t, "rogue", newTestCertificate(t, "rogue-ca", nil))
+	dir := t.TempDir()
+	caFile, _ := ca.write(t, dir)
+	certFile, keyFile := server.write(t, dir)
+
+	web := &config.WebConfiguration{TlsServer: &config.TlsServerConfiguration{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientAuthType: "RequireAndVerifyClientCert",
+		ClientCaFile:   caFile,
+	}}
+	tlsConfig, err := newTlsConfig(web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewUnstartedServer(newAuthHandler(web, http.NotFoundHandler()))
+	s.TLS = tlsConfig
+	s.StartTLS()
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	get := func(path string, certificate *testCertificate) (int, error) {
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if certificate != nil {
+			// The certificate is sent even if it is not issued by the CA accepted by the server.
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				c := certificate.tls()
+				return &c, nil
+			}
+		}
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		res, err := httpClient.Get(s.URL + path)
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		return res.StatusCode, nil
+	}
+
+	if status, err := get("/healthz", nil); err != nil || status != http.StatusNotFound {
+		t.Errorf("health check requires client certificate: %d %v", status, err)
+	}
+	if status, err := get("/metrics", nil); err != nil || status != http.StatusForbidden {
+		t.Errorf("metrics are served without client certificate: %d %v", status, err)
+	}
+	if status, err := get("/metrics", client); err != nil || status != http.StatusNotFound {
+		t.Errorf("metrics are not served with client certificate: %d %v", status, err)
+	}
+	if _, err := get("/healthz", rogue); err == nil {
+		t.Error("untrusted client certificate is accepted by the handshake")
+	}
+}
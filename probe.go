@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/csutorasa/icon-metrics/client"
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/logging"
+	"github.com/csutorasa/icon-metrics/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Reads a single iCON device on request and publishes only its metrics.
+type probeHandler struct {
+	configuration atomic.Pointer[config.Configuration]
+}
+
+// Creates a new handler for the probe requests.
+func newProbeHandler(configuration *config.Configuration) *probeHandler {
+	handler := &probeHandler{}
+	handler.configure(configuration)
+	return handler
+}
+
+// Replaces the configuration of the devices and modules.
+func (handler *probeHandler) configure(configuration *config.Configuration) {
+	handler.configuration.Store(configuration)
+}
+
+// Probes the target and writes the metrics.
+func (handler *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	configuration := handler.configuration.Load()
+	device, err := resolveTarget(configuration, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	registry := prometheus.NewRegistry()
+	successGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "icon_probe_success",
+		Help: "Reports 1 if the probe was successful, 0 otherwise",
+	})
+	durationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "icon_probe_duration_seconds",
+		Help: "Reports the duration of the probe",
+	})
+	registry.MustRegister(successGauge, durationGauge)
+	reporter := metrics.NewPrometheusReporter(registry, configuration)
+	session := metrics.NewSession(device, reporter)
+	c, err := client.NewIconClient(device.Url, device.SysId, device.Password, session)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid target: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	timer := metrics.NewTimer()
+	if poll(c, session) == nil {
+		successGauge.Set(1)
+	}
+	if c.IsLoggedIn() {
+		err = c.Close()
+		if err != nil {
+			c.Logger().Warn("Failed to disconnect", logging.Operation("probe"), logging.Error(err))
+		}
+	}
+	durationGauge.Set(timer.End().Seconds())
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// Returns the device configuration of the probe target.
+func resolveTarget(configuration *config.Configuration, query url.Values) (*config.IconConfiguration, error) {
+	target := query.Get("target")
+	if target == "" {
+		return nil, fmt.Errorf("target parameter is missing")
+	}
+	moduleName := query.Get("module")
+	if moduleName == "" {
+		for _, device := range configuration.Devices {
+			if device.SysId == target {
+				return device, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown target %s", target)
+	}
+	module, ok := configuration.Modules[moduleName]
+	if !ok {
+		return nil, fmt.Errorf("unknown module %s", moduleName)
+	}
+	if !module.AllowsTarget(target) {
+		return nil, fmt.Errorf("target %s is not allowed by module %s", target, moduleName)
+	}
+	device := *module
+	device.Url = target
+	if query.Has("sysid") {
+		device.SysId = query.Get("sysid")
+	}
+	if device.SysId == "" {
+		return nil, fmt.Errorf("sysid parameter is missing")
+	}
+	if device.Password == "" {
+		device.Password = device.SysId
+	}
+	return &device, nil
+}
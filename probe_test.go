@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/csutorasa/icon-metrics/config"
+)
+
+const probeConfig = `
+probe:
+  enabled: true
+devices:
+  - url: http://192.168.1.10
+    sysid: '123456789012'
+    password: device
+modules:
+  default:
+    targets: ['192.168.1.*']
+    password: module
+    sysid: '210987654321'
+  open:
+    targets: ['*']
+`
+
+func TestResolveTarget(t *testing.T) {
+	c, err := config.ParseConfig([]byte(probeConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device, err := resolveTarget(c, url.Values{"target": {"123456789012"}})
+	if err != nil || device != c.Devices[0] {
+		t.Errorf("configured device is not resolved by sysid: %v", err)
+	}
+
+	device, err = resolveTarget(c, url.Values{"target": {"http://192.168.1.11"}, "module": {"default"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Url != "http://192.168.1.11" || device.SysId != "210987654321" || device.Password != "module" {
+		t.Errorf("module settings are not applied: %+v", device)
+	}
+	if c.Modules["default"].Url != "" {
+		t.Error("module is changed by the probe")
+	}
+
+	device, err = resolveTarget(c, url.Values{"target": {"http://icon:8080"}, "module": {"open"}, "sysid": {"111111111111"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.SysId != "111111111111" || device.Password != "111111111111" {
+		t.Errorf("sysid parameter is not applied: %+v", device)
+	}
+
+	for query, message := range map[string]string{
+		"":                                      "target parameter is missing",
+		"target=999999999999":                   "unknown target",
+		"target=http://192.168.1.11&module=x":   "unknown module",
+		"target=http://10.0.0.1&module=default": "is not allowed by module",
+		"target=http://192.168.1.11.example.com&module=default": "is not allowed by module",
+		"target=http://icon&module=open":                        "sysid parameter is missing",
+	} {
+		values, _ := url.ParseQuery(query)
+		_, err := resolveTarget(c, values)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected %q error for %s, got %v", message, query, err)
+		}
+	}
+}
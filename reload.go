@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/logging"
+	"github.com/csutorasa/icon-metrics/metrics"
+)
+
+// Reloads the configuration file and applies the changes.
+type configReloader struct {
+	path          string
+	configuration *config.Configuration
+	discovery     *deviceDiscovery
+	probe         *probeHandler
+	health        *healthHandler
+	reporter      metrics.ReloadReporter
+	// Serialises the reloads.
+	lock sync.Mutex
+}
+
+// Creates a new reloader for the already loaded configuration.
+func newConfigReloader(path string, configuration *config.Configuration, discovery *deviceDiscovery, probe *probeHandler, health *healthHandler, reporter metrics.ReloadReporter) *configReloader {
+	return &configReloader{
+		path:          path,
+		configuration: configuration,
+		discovery:     discovery,
+		probe:         probe,
+		health:        health,
+		reporter:      reporter,
+	}
+}
+
+// Reads the configuration file and applies it, invalid configurations are rejected.
+func (reloader *configReloader) reload() error {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+	c, err := readConfig(reloader.path)
+	if err == nil {
+		err = checkReload(reloader.configuration, c)
+	}
+	if err == nil {
+		err = logging.SetLevel(c.Log)
+	}
+	if err != nil {
+		slog.Error("Failed to reload configuration", logging.Operation("reload"), logging.Error(err))
+		reloader.reporter.Reloaded(false)
+		return err
+	}
+	start := metrics.NewTimer()
+	reloader.discovery.configure(c)
+	reloader.probe.configure(c)
+	reloader.health.configure(c)
+	reloader.configuration = c
+	reloader.reporter.Reloaded(true)
+	slog.Info("Successfully reloaded configuration", logging.Operation("reload"), slog.Duration("duration", start.End()))
+	return nil
+}
+
+// Returns the last successfully applied configuration.
+func (reloader *configReloader) current() *config.Configuration {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+	return reloader.configuration
+}
+
+// Reloads the configuration when the file is modified.
+func (reloader *configReloader) watch(interval time.Duration) {
+	modified := func() time.Time {
+		info, err := os.Stat(reloader.path)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	last := modified()
+	for {
+		time.Sleep(interval)
+		current := modified()
+		if current.IsZero() || current.Equal(last) {
+			continue
+		}
+		last = current
+		slog.Info("Configuration file is modified, reloading", logging.Operation("reload"), slog.String("path", reloader.path))
+		reloader.reload()
+	}
+}
+
+// Returns an error if the configuration change cannot be applied without restart.
+func checkReload(previous *config.Configuration, current *config.Configuration) error {
+	if !slices.Equal(previous.DeviceLabelNames(), current.DeviceLabelNames()) {
+		return fmt.Errorf("device label names cannot be changed without restart")
+	}
+	if !slices.Equal(previous.RoomLabelNames(), current.RoomLabelNames()) {
+		return fmt.Errorf("room label names cannot be changed without restart")
+	}
+	if previous.Port != current.Port || previous.Address != current.Address {
+		slog.Warn("Port and address change is ignored until restart", logging.Operation("reload"))
+	}
+	if *previous.Probe.Enabled != *current.Probe.Enabled {
+		slog.Warn("Probe endpoint change is ignored until restart", logging.Operation("reload"))
+	}
+	if !reflect.DeepEqual(previous.Admin, current.Admin) {
+		slog.Warn("Admin configuration change is ignored until restart", logging.Operation("reload"))
+	}
+	if previous.WebConfigFile != current.WebConfigFile || !reflect.DeepEqual(previous.Web, current.Web) {
+		slog.Warn("Web configuration change is ignored until restart", logging.Operation("reload"))
+	}
+	if !reflect.DeepEqual(previous.Compatibility, current.Compatibility) {
+		slog.Warn("Compatibility configuration change is ignored until restart", logging.Operation("reload"))
+	}
+	if !reflect.DeepEqual(previous.Collectors, current.Collectors) {
+		slog.Warn("Collectors configuration change is ignored until restart", logging.Operation("reload"))
+	}
+	if !reflect.DeepEqual(previous.HttpClient, current.HttpClient) {
+		slog.Warn("HTTP client configuration change is ignored until restart", logging.Operation("reload"))
+	}
+	if previous.Log.Format != current.Log.Format || previous.Log.Output != current.Log.Output {
+		slog.Warn("Log format and output change is ignored until restart", logging.Operation("reload"))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/csutorasa/icon-metrics/config"
+)
+
+// Returns the parsed configuration or fails the test.
+func parseTestConfig(t *testing.T, content string) *config.Configuration {
+	t.Helper()
+	c, err := config.ParseConfig([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCheckReload(t *testing.T) {
+	previous := parseTestConfig(t, `
+devices:
+  - url: http://192.168.1.10
+    sysid: '123456789012'
+    labels:
+      site: home
+    roomLabels:
+      '1':
+        floor: ground
+`)
+	changed := parseTestConfig(t, `
+port: 9090
+shutdownTimeout: 10
+devices:
+  - url: http://192.168.1.11
+    sysid: '210987654321'
+    delay: 60
+    labels:
+      site: office
+    roomLabels:
+      '2':
+        floor: first
+`)
+	if err := checkReload(previous, changed); err != nil {
+		t.Errorf("device and restart-only changes are rejected: %v", err)
+	}
+
+	deviceLabels := parseTestConfig(t, `
+devices:
+  - url: http://192.168.1.10
+    sysid: '123456789012'
+    labels:
+      zone: north
+    roomLabels:
+      '1':
+        floor: ground
+`)
+	if err := checkReload(previous, deviceLabels); err == nil {
+		t.Error("device label name change is accepted")
+	}
+
+	roomLabels := parseTestConfig(t, `
+devices:
+  - url: http://192.168.1.10
+    sysid: '123456789012'
+    labels:
+      site: home
+`)
+	if err := checkReload(previous, roomLabels); err == nil {
+		t.Error("room label name change is accepted")
+	}
+}
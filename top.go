@@ -0,0 +1,504 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/client"
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/model"
+	"golang.org/x/term"
+)
+
+// ANSI escape sequences of the terminal view.
+const (
+	ansiAlternateScreen = "\x1b[?1049h"
+	ansiMainScreen      = "\x1b[?1049l"
+	ansiHideCursor      = "\x1b[?25l"
+	ansiShowCursor      = "\x1b[?25h"
+	ansiClear           = "\x1b[H\x1b[2J"
+	ansiBold            = "\x1b[1m"
+	ansiReverse         = "\x1b[7m"
+	ansiChanged         = "\x1b[1;33m"
+	ansiError           = "\x1b[31m"
+	ansiReset           = "\x1b[0m"
+)
+
+// Keyboard actions of the terminal view.
+const (
+	keyQuit = iota
+	keyUp
+	keyDown
+	keyRaise
+	keyLower
+	keyRefresh
+)
+
+// Target temperature change of a single key press.
+const nudgeStep = 0.5
+
+// Polled device of the terminal view.
+type topDevice struct {
+	configuration *config.IconConfiguration
+	// Client of the device, only used by the poller.
+	client client.IconClient
+	values *model.DataPollResponse
+	err    error
+}
+
+// Request of the poller, the target change is sent before the devices are polled.
+type topRequest struct {
+	// Device of the target change, nil if only the devices are polled.
+	device   *topDevice
+	settings model.ThermostatSettings
+	// Description of the target change.
+	change string
+}
+
+// Result of a poller request.
+type topResult struct {
+	// Values and errors of the devices in the order of the view.
+	values []*model.DataPollResponse
+	errs   []error
+	// Result of the target change.
+	message string
+}
+
+// Room row of the terminal view.
+type topRoom struct {
+	device *topDevice
+	id     string
+}
+
+// Live terminal view of the devices.
+type topView struct {
+	configuration *config.Configuration
+	devices       []*topDevice
+	tab           int
+	// Key of the selected room.
+	selected string
+	// Cell values of the previous poll by cell key.
+	cells map[string]string
+	// Cells changed by the last poll.
+	changed map[string]bool
+	// Result of the last action.
+	message string
+	// Number of the requests sent to the poller without result.
+	pending  int
+	requests chan *topRequest
+	out      io.Writer
+}
+
+// Polls the devices and renders a live table, the target of the selected room can be changed.
+func topCommand(flags *flag.FlagSet) func() error {
+	configPath := configFlag(flags)
+	sysId := deviceFlag(flags)
+	interval := flags.Duration("interval", 5*time.Second, "Interval between the polls")
+	tab := flags.Int("tab", 0, "Controller tab of the rooms to set the target temperature of")
+	return func() error {
+		if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+			return errors.New("top requires an interactive terminal")
+		}
+		c, err := readCommandConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		devices, err := selectDevices(c, *sysId)
+		if err != nil {
+			return err
+		}
+		view := &topView{
+			configuration: c,
+			tab:           *tab,
+			cells:         make(map[string]string),
+			changed:       make(map[string]bool),
+			requests:      make(chan *topRequest, 16),
+			out:           os.Stdout,
+		}
+		for _, device := range devices {
+			view.devices = append(view.devices, &topDevice{configuration: device})
+		}
+		defer view.close()
+		state, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return fmt.Errorf("failed to set up the terminal: %w", err)
+		}
+		defer term.Restore(int(os.Stdin.Fd()), state)
+		fmt.Fprint(view.out, ansiAlternateScreen+ansiHideCursor)
+		defer fmt.Fprint(view.out, ansiShowCursor+ansiMainScreen)
+		return view.run(*interval)
+	}
+}
+
+// Renders until quit, the devices are polled in the background.
+func (view *topView) run(interval time.Duration) error {
+	keys := make(chan int, 16)
+	go readKeys(os.Stdin, keys)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	results := make(chan *topResult)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		view.poller(done, results)
+	}()
+	// The clients are closed after the last request of the poller is finished.
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	view.poll()
+	for {
+		view.render()
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C:
+			if view.pending == 0 {
+				view.poll()
+			}
+		case result := <-results:
+			view.update(result)
+		case key := <-keys:
+			switch key {
+			case keyQuit:
+				return nil
+			case keyUp:
+				view.move(-1)
+			case keyDown:
+				view.move(1)
+			case keyRaise:
+				view.nudge(nudgeStep)
+			case keyLower:
+				view.nudge(-nudgeStep)
+			case keyRefresh:
+				if view.pending == 0 {
+					view.poll()
+				}
+			}
+		}
+	}
+}
+
+// Reads the key presses and sends the actions, quit is sent when the input is closed.
+func readKeys(in io.Reader, keys chan<- int) {
+	buffer := make([]byte, 8)
+	for {
+		n, err := in.Read(buffer)
+		if err != nil {
+			keys <- keyQuit
+			return
+		}
+		switch input := string(buffer[:n]); input {
+		case "q", "Q", "\x03", "\x04":
+			keys <- keyQuit
+		case "k", "\x1b[A", "\x1bOA":
+			keys <- keyUp
+		case "j", "\x1b[B", "\x1bOB":
+			keys <- keyDown
+		case "+", "=", "\x1b[C", "\x1bOC":
+			keys <- keyRaise
+		case "-", "_", "\x1b[D", "\x1bOD":
+			keys <- keyLower
+		case "r", "R":
+			keys <- keyRefresh
+		}
+	}
+}
+
+// Sends a request to the poller, returns false if too many requests are pending.
+func (view *topView) send(request *topRequest) bool {
+	select {
+	case view.requests <- request:
+		view.pending++
+		return true
+	default:
+		return false
+	}
+}
+
+// Requests the values of every device.
+func (view *topView) poll() {
+	view.send(&topRequest{})
+}
+
+// Serves the requests until done, the clients are reconnected after failures.
+func (view *topView) poller(done <-chan struct{}, results chan<- *topResult) {
+	for {
+		var request *topRequest
+		select {
+		case <-done:
+			return
+		case request = <-view.requests:
+		}
+		result := &topResult{}
+		if request.device != nil {
+			result.message = view.change(request)
+		}
+		for _, device := range view.devices {
+			values, err := view.read(device)
+			result.values = append(result.values, values)
+			result.errs = append(result.errs, err)
+		}
+		select {
+		case <-done:
+			return
+		case results <- result:
+		}
+	}
+}
+
+// Sends the target change of the request, returns the message of the result.
+func (view *topView) change(request *topRequest) string {
+	if request.device.client == nil || !request.device.client.IsLoggedIn() {
+		return fmt.Sprintf("Failed to set %s: device is not connected", request.change)
+	}
+	err := request.device.client.SetThermostatSettings(view.tab, request.settings)
+	if err != nil {
+		return fmt.Sprintf("Failed to set %s: %s", request.change, err.Error())
+	}
+	return fmt.Sprintf("%s is set", request.change)
+}
+
+// Shows the result of the poller.
+func (view *topView) update(result *topResult) {
+	view.pending--
+	if result.message != "" {
+		view.message = result.message
+	}
+	cells := make(map[string]string)
+	for i, device := range view.devices {
+		device.values, device.err = result.values[i], result.errs[i]
+		view.collectCells(cells, device)
+	}
+	view.changed = make(map[string]bool)
+	for key, value := range cells {
+		previous, ok := view.cells[key]
+		view.changed[key] = ok && previous != value
+	}
+	view.cells = cells
+	rooms := view.rooms()
+	if view.selected == "" && len(rooms) > 0 {
+		view.selected = rooms[0].key()
+	}
+}
+
+// Reads the values of the device, logs in if there is no session.
+func (view *topView) read(device *topDevice) (*model.DataPollResponse, error) {
+	if device.client == nil || !device.client.IsLoggedIn() {
+		c, err := connect(view.configuration, device.configuration)
+		if err != nil {
+			return nil, err
+		}
+		device.client = c
+	}
+	return device.client.ReadValues()
+}
+
+// Logs out from every device, must not be called while the poller is running.
+func (view *topView) close() {
+	for _, device := range view.devices {
+		if device.client != nil && device.client.IsLoggedIn() {
+			device.client.Close()
+		}
+	}
+}
+
+// Returns the rooms of every device in display order.
+func (view *topView) rooms() []*topRoom {
+	rooms := make([]*topRoom, 0)
+	for _, device := range view.devices {
+		if device.values == nil {
+			continue
+		}
+		ids := make([]string, 0, len(device.values.Thermostats))
+		for id, thermostat := range device.values.Thermostats {
+			if thermostat.Enabled != 0 {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return roomIdLess(ids[i], ids[j])
+		})
+		for _, id := range ids {
+			rooms = append(rooms, &topRoom{device: device, id: id})
+		}
+	}
+	return rooms
+}
+
+// Returns the unique key of the room.
+func (room *topRoom) key() string {
+	return room.device.configuration.SysId + "/" + room.id
+}
+
+// Moves the selection by the offset.
+func (view *topView) move(offset int) {
+	rooms := view.rooms()
+	if len(rooms) == 0 {
+		return
+	}
+	index := 0
+	for i, room := range rooms {
+		if room.key() == view.selected {
+			index = i
+		}
+	}
+	index = min(max(index+offset, 0), len(rooms)-1)
+	view.selected = rooms[index].key()
+}
+
+// Requests the change of the active target temperature of the selected room.
+func (view *topView) nudge(delta float64) {
+	var selected *topRoom
+	for _, room := range view.rooms() {
+		if room.key() == view.selected {
+			selected = room
+		}
+	}
+	if selected == nil {
+		view.message = "No room is selected"
+		return
+	}
+	thermostat := selected.device.values.Thermostats[selected.id]
+	signal, err := strconv.Atoi(selected.id)
+	if err != nil {
+		view.message = fmt.Sprintf("Room %s cannot be set", selected.id)
+		return
+	}
+	setting := thermostatSetting(thermostat)
+	target := activeTarget(setting, thermostat)
+	*target += delta
+	sent := view.send(&topRequest{
+		device:   selected.device,
+		settings: model.ThermostatSettings{signal: setting},
+		change:   fmt.Sprintf("%s target to %.1f", thermostat.Name, *target),
+	})
+	if !sent {
+		view.message = "Too many changes are waiting for the devices"
+		return
+	}
+	// The next key press changes the sent target until the devices are polled again.
+	thermostat.HeatingTargetTemperature = setting.HeatingTargetTemperature
+	thermostat.EcoHeatingTargetTemperature = setting.EcoHeatingTargetTemperature
+	thermostat.CoolingTargetTemperature = setting.CoolingTargetTemperature
+	thermostat.EcoCoolingTargetTemperature = setting.EcoCoolingTargetTemperature
+	view.message = fmt.Sprintf("Setting %s target to %.1f", thermostat.Name, *target)
+}
+
+// Returns the target temperature setting of the current heating or cooling and comfort or eco mode.
+func activeTarget(setting *model.ThermostatSetting, thermostat *model.DP) *float64 {
+	if thermostat.HeatingCooling == model.Heating {
+		if thermostat.ComfortEco == model.Comfort {
+			return &setting.HeatingTargetTemperature
+		}
+		return &setting.EcoHeatingTargetTemperature
+	}
+	if thermostat.ComfortEco == model.Comfort {
+		return &setting.CoolingTargetTemperature
+	}
+	return &setting.EcoCoolingTargetTemperature
+}
+
+// Collects the displayed cell values of the device by cell key.
+func (view *topView) collectCells(cells map[string]string, device *topDevice) {
+	sysId := device.configuration.SysId
+	values := device.values
+	if values == nil {
+		return
+	}
+	cells[sysId+"/mode"] = heatingCoolingText(values.HeatingCooling, values.ComfortEco)
+	cells[sysId+"/water"] = fmt.Sprintf("%.1f", values.WaterTemperature)
+	cells[sysId+"/external"] = fmt.Sprintf("%.1f", values.ExternalTemperature)
+	cells[sysId+"/pump"] = onOff(values.Pump)
+	for id, thermostat := range values.Thermostats {
+		key := sysId + "/" + id
+		cells[key+"/name"] = thermostat.Name
+		cells[key+"/temperature"] = fmt.Sprintf("%.1f", thermostat.Temperature)
+		cells[key+"/target"] = fmt.Sprintf("%.1f", thermostat.TargetTemperature())
+		cells[key+"/humidity"] = fmt.Sprintf("%.1f", thermostat.RelativeHumidity)
+		cells[key+"/dew"] = fmt.Sprintf("%.1f", thermostat.DewTemperature)
+		cells[key+"/relay"] = relayIndicator(thermostat.Relay)
+	}
+}
+
+// Returns on or off.
+func onOff(value int) string {
+	if value != 0 {
+		return "on"
+	}
+	return "off"
+}
+
+// Returns a filled circle if the relay is on.
+func relayIndicator(relay int) string {
+	if relay != 0 {
+		return "●"
+	}
+	return "○"
+}
+
+// Renders the devices and the rooms.
+func (view *topView) render() {
+	var b strings.Builder
+	b.WriteString(ansiClear)
+	line := func(s string) {
+		b.WriteString(s)
+		b.WriteString("\r\n")
+	}
+	polling := ""
+	if view.pending > 0 {
+		polling = " - polling"
+	}
+	line(ansiBold + fmt.Sprintf("icon-metrics top - %s%s", time.Now().Format(time.TimeOnly), polling) + ansiReset)
+	line("")
+	line(ansiBold + fmt.Sprintf("%-14s %-16s %8s %8s %5s  %s", "SYSID", "MODE", "WATER", "EXTERNAL", "PUMP", "STATUS") + ansiReset)
+	for _, device := range view.devices {
+		sysId := device.configuration.SysId
+		status := "ok"
+		if device.err != nil {
+			status = ansiError + device.err.Error() + ansiReset
+		}
+		line(fmt.Sprintf("%-14s %s %s %s %s  %s", sysId,
+			view.cell(sysId+"/mode", -16), view.cell(sysId+"/water", 8), view.cell(sysId+"/external", 8), view.cell(sysId+"/pump", 5), status))
+	}
+	line("")
+	line(ansiBold + fmt.Sprintf("  %-14s %-5s %-20s %6s %6s %6s %6s %5s", "SYSID", "ROOM", "NAME", "TEMP", "TARGET", "RH", "DEW", "RELAY") + ansiReset)
+	for _, room := range view.rooms() {
+		key := room.key()
+		marker := "  "
+		if key == view.selected {
+			marker = ansiReverse + "> " + ansiReset
+		}
+		line(fmt.Sprintf("%s%-14s %-5s %s %s %s %s %s %s", marker, room.device.configuration.SysId, room.id,
+			view.cell(key+"/name", -20), view.cell(key+"/temperature", 6), view.cell(key+"/target", 6),
+			view.cell(key+"/humidity", 6), view.cell(key+"/dew", 6), view.cell(key+"/relay", 5)))
+	}
+	line("")
+	line(view.message)
+	b.WriteString("↑/↓ select room  +/- change target  r refresh  q quit")
+	fmt.Fprint(view.out, b.String())
+}
+
+// Returns the padded cell value, highlighted if changed by the last poll.
+// Negative widths are aligned to the left.
+func (view *topView) cell(key string, width int) string {
+	text := fmt.Sprintf("%*s", width, view.cells[key])
+	if view.changed[key] {
+		return ansiChanged + text + ansiReset
+	}
+	return text
+}
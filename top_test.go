@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/csutorasa/icon-metrics/config"
+	"github.com/csutorasa/icon-metrics/model"
+)
+
+// Returns a view of the configured devices without a running poller.
+func newTestTopView(c *config.Configuration) *topView {
+	view := &topView{
+		configuration: c,
+		cells:         make(map[string]string),
+		changed:       make(map[string]bool),
+		requests:      make(chan *topRequest, 16),
+		out:           io.Discard,
+	}
+	for _, device := range c.Devices {
+		view.devices = append(view.devices, &topDevice{configuration: device})
+	}
+	return view
+}
+
+func TestTopPollDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.NotFound(w, r)
+	}))
+	defer device.Close()
+	view := newTestTopView(parseTestConfig(t, `
+devices:
+  - url: `+device.URL+`
+    sysid: '123456789012'
+`))
+	results := make(chan *topResult)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		view.poller(done, results)
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	start := time.Now()
+	view.poll()
+	view.render()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("poll blocks the view while the device does not respond, took %v", elapsed)
+	}
+	if view.pending != 1 {
+		t.Errorf("expected 1 pending request, got %d", view.pending)
+	}
+
+	close(release)
+	select {
+	case result := <-results:
+		view.update(result)
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll result is not sent")
+	}
+	if view.pending != 0 || view.devices[0].err == nil {
+		t.Errorf("failed poll is not shown, pending %d, error %v", view.pending, view.devices[0].err)
+	}
+}
+
+func TestTopNudge(t *testing.T) {
+	view := newTestTopView(parseTestConfig(t, `
+devices:
+  - url: http://192.168.1.10
+    sysid: '123456789012'
+`))
+	view.update(&topResult{
+		values: []*model.DataPollResponse{{Thermostats: map[string]*model.DP{
+			"1": {Enabled: 1, Name: "Living room", HeatingCooling: model.Heating, ComfortEco: model.Comfort, HeatingTargetTemperature: 21},
+		}}},
+		errs: []error{nil},
+	})
+	view.pending = 0
+	if view.selected != "123456789012/1" {
+		t.Fatalf("first room is not selected, got %q", view.selected)
+	}
+
+	view.nudge(nudgeStep)
+	view.nudge(nudgeStep)
+	if view.pending != 2 || len(view.requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(view.requests))
+	}
+	<-view.requests
+	request := <-view.requests
+	if target := request.settings[1].HeatingTargetTemperature; target != 22 {
+		t.Errorf("second change does not continue from the first, got %.1f", target)
+	}
+}